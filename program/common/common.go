@@ -34,7 +34,7 @@ func PathExists(path string) (bool, error) {
 	return false, err
 }
 
-// HexToColor 16进制颜色转Color
+// HexToColor 16进制颜色转Color - 支持#RRGGBB和带透明度的#RRGGBBAA
 func HexToColor(str string) (color.Color, error) {
 	if len(str) < 6 {
 		return nil, errors.New("Illegal hexadecimal color")
@@ -42,7 +42,7 @@ func HexToColor(str string) (color.Color, error) {
 	if strings.HasPrefix(str, "#") == true {
 		str = str[1:]
 	}
-	if len(str) != 6 {
+	if len(str) != 6 && len(str) != 8 {
 		return nil, errors.New("Illegal hexadecimal color")
 	}
 
@@ -54,15 +54,23 @@ func HexToColor(str string) (color.Color, error) {
 	if err != nil {
 		return nil, err
 	}
-	b, err := strconv.ParseInt(str[4:], 16, 10)
+	b, err := strconv.ParseInt(str[4:6], 16, 10)
 	if err != nil {
 		return nil, err
 	}
+	a := int64(255)
+	if len(str) == 8 {
+		a, err = strconv.ParseInt(str[6:], 16, 10)
+		if err != nil {
+			return nil, err
+		}
+	}
 
+	// color.RGBA为预乘透明度的颜色
 	return color.RGBA{
-		R: uint8(r),
-		G: uint8(g),
-		B: uint8(b),
-		A: 255,
+		R: uint8(r * a / 255),
+		G: uint8(g * a / 255),
+		B: uint8(b * a / 255),
+		A: uint8(a),
 	}, nil
 }
@@ -12,3 +12,17 @@ func TestHexToColor(t *testing.T) {
 	}
 	t.Log(c)
 }
+
+func TestHexToColorAlpha(t *testing.T) {
+	c, err := HexToColor("#FF000080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, g, b, a := c.RGBA()
+	if r>>8 != 0x80 || g != 0 || b != 0 || a>>8 != 0x80 {
+		t.Fatalf("got %v", c)
+	}
+	if _, err = HexToColor("#FF0000800"); err == nil {
+		t.Fatal("expected illegal color error")
+	}
+}
@@ -16,10 +16,14 @@ var (
 
 // Config 配置文件对应对象
 type Config struct {
-	Debug   bool        `toml:"debug"`
-	LogPath string      `toml:"log_path"`
-	HTTP    *HTTPConfig `toml:"http"`
-	GRPC    *GRPCConfig `toml:"grpc"`
+	Debug          bool        `toml:"debug"`
+	LogPath        string      `toml:"log_path"`
+	ResourcesDir   string      `toml:"resources_dir"`   // 资源目录 - 字体放在其中的fonts目录，默认./resources
+	TemplatesDir   string      `toml:"templates_dir"`   // 模板目录 - 其中的json、toml文件作为海报模板，修改后自动重新加载，默认./config/templates
+	TemplatesStore string      `toml:"templates_store"` // 模板存储文件 - 所有模板及其版本写入该文件，重启后加载，版本号保持不变，默认./data/templates.json
+	HTTP           *HTTPConfig `toml:"http"`
+	GRPC           *GRPCConfig `toml:"grpc"`
+	Font           *FontConfig `toml:"font"`
 }
 
 // HTTPConfig http 监听配置
@@ -38,6 +42,12 @@ type GRPCConfig struct {
 	Port    int    `toml:"port"`
 }
 
+// FontConfig 字体配置
+type FontConfig struct {
+	Fallbacks []string `toml:"fallbacks"` // 全局备用字体 - 文本字体中没有的字符依次从这些字体中查找
+	EmojiDir  string   `toml:"emoji_dir"` // 彩色表情图片目录 - 图片名为码位十六进制，如1f600.png，不支持彩色表情字体
+}
+
 // LoadConfig 读取配置
 func LoadConfig(cfgPath string) (*Config, error) {
 	if cfgPath == "" {
@@ -52,6 +62,15 @@ func LoadConfig(cfgPath string) (*Config, error) {
 	if err := toml.NewDecoder(f).Decode(CFG); err != nil {
 		return nil, err
 	}
+	if CFG.ResourcesDir == "" {
+		CFG.ResourcesDir = "./resources"
+	}
+	if CFG.TemplatesDir == "" {
+		CFG.TemplatesDir = "./config/templates"
+	}
+	if CFG.TemplatesStore == "" {
+		CFG.TemplatesStore = "./data/templates.json"
+	}
 	// 检查配置项是否全
 	if CFG.HTTP == nil || CFG.GRPC == nil {
 		return CFG, errors.New("Configure at least one of HTTP or grpc")
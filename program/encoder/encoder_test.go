@@ -0,0 +1,238 @@
+package encoder
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"math/rand"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+// testImage 左半渐变、右半纯色、底部半透明的图片
+func testImage(w, h int) *image.NRGBA {
+	m := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.NRGBA{uint8(x * 255 / w), uint8(y * 255 / h), uint8((x + y) % 256), 255}
+			if x >= w/2 {
+				c = color.NRGBA{200, 30, 60, 255}
+			}
+			if y >= h*3/4 {
+				c.A = uint8(x * 255 / w)
+			}
+			m.SetNRGBA(x, y, c)
+		}
+	}
+	return m
+}
+
+func TestEncodeWebP(t *testing.T) {
+	for _, size := range [][2]int{{1, 1}, {37, 23}, {300, 200}} {
+		src := testImage(size[0], size[1])
+		buf := new(bytes.Buffer)
+		if err := EncodeWebP(buf, src); err != nil {
+			t.Fatal(err)
+		}
+		img, err := webp.Decode(buf)
+		if err != nil {
+			t.Fatalf("%v decode error: %v", size, err)
+		}
+		if img.Bounds() != src.Bounds() {
+			t.Fatalf("%v bounds got %v", size, img.Bounds())
+		}
+		// 无损，每个像素都相同
+		for y := 0; y < size[1]; y++ {
+			for x := 0; x < size[0]; x++ {
+				want := src.NRGBAAt(x, y)
+				if want.A == 0 {
+					continue
+				}
+				if got := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA); got != want {
+					t.Fatalf("%v pixel (%d,%d) got %v want %v", size, x, y, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestEncodeProgressiveJPEG(t *testing.T) {
+	for _, size := range [][2]int{{1, 1}, {37, 23}, {300, 200}} {
+		src := testImage(size[0], size[1])
+		for y := 0; y < size[1]; y++ {
+			for x := 0; x < size[0]; x++ {
+				c := src.NRGBAAt(x, y)
+				c.A = 255
+				src.SetNRGBA(x, y, c)
+			}
+		}
+		buf := new(bytes.Buffer)
+		if err := EncodeProgressiveJPEG(buf, src, 90); err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(buf.Bytes(), []byte{0xff, 0xc2}) == false {
+			t.Fatalf("%v missing SOF2 marker", size)
+		}
+		img, err := jpeg.Decode(buf)
+		if err != nil {
+			t.Fatalf("%v decode error: %v", size, err)
+		}
+		if img.Bounds() != src.Bounds() {
+			t.Fatalf("%v bounds got %v", size, img.Bounds())
+		}
+		// 纯色区域误差很小
+		x, y := size[0]*3/4, size[1]/2
+		r, g, b, _ := img.At(x, y).RGBA()
+		want := src.NRGBAAt(x, y)
+		if diff(r>>8, want.R) > 12 || diff(g>>8, want.G) > 12 || diff(b>>8, want.B) > 12 {
+			t.Fatalf("%v pixel (%d,%d) got %d,%d,%d want %v", size, x, y, r>>8, g>>8, b>>8, want)
+		}
+	}
+}
+
+// 和标准库基线jpg比较画质 - 相同质量下PSNR不能明显更低
+func TestProgressiveJPEGQuality(t *testing.T) {
+	w, h := 256, 192
+	ycc := image.NewYCbCr(image.Rect(0, 0, w, h), image.YCbCrSubsampleRatio420)
+	rgba := image.NewRGBA(ycc.Bounds())
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			fx, fy := float64(x), float64(y)
+			ycc.Y[ycc.YOffset(x, y)] = uint8(128 + 60*math.Sin(fx/9)*math.Cos(fy/7) + 40*math.Sin(fx*fy/900))
+			ycc.Cb[ycc.COffset(x, y)] = uint8(128 + 50*math.Sin(fx/23+fy/31))
+			ycc.Cr[ycc.COffset(x, y)] = uint8(128 + 50*math.Cos(fx/29-fy/17))
+		}
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			rgba.Set(x, y, ycc.At(x, y))
+		}
+	}
+	for _, src := range []image.Image{ycc, rgba} {
+		for _, quality := range []int{50, 75, 90, 100} {
+			std, prog := new(bytes.Buffer), new(bytes.Buffer)
+			if err := jpeg.Encode(std, src, &jpeg.Options{Quality: quality}); err != nil {
+				t.Fatal(err)
+			}
+			if err := EncodeProgressiveJPEG(prog, src, quality); err != nil {
+				t.Fatal(err)
+			}
+			stdImg, err := jpeg.Decode(std)
+			if err != nil {
+				t.Fatal(err)
+			}
+			progImg, err := jpeg.Decode(prog)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, got := psnr(src, stdImg), psnr(src, progImg)
+			if got < want-0.5 {
+				t.Fatalf("%T quality %d PSNR got %.2f dB, image/jpeg %.2f dB", src, quality, got, want)
+			}
+		}
+	}
+}
+
+// roundTripImages 随机尺寸和内容的图片 - 奇数尺寸、起点不为0、只有透明度、全透明、灰度、调色板
+func roundTripImages() []image.Image {
+	rnd := rand.New(rand.NewSource(1))
+	var images []image.Image
+	for i := 0; i < 40; i++ {
+		w, h := 1+rnd.Intn(70), 1+rnd.Intn(70)
+		m := image.NewNRGBA(image.Rect(0, 0, w+3, h+5))
+		rnd.Read(m.Pix)
+		images = append(images, m.SubImage(image.Rect(3, 5, w+3, h+5)))
+	}
+	alpha := image.NewAlpha(image.Rect(0, 0, 17, 9))
+	rnd.Read(alpha.Pix)
+	gray := image.NewGray(image.Rect(0, 0, 9, 31))
+	rnd.Read(gray.Pix)
+	paletted := image.NewPaletted(image.Rect(0, 0, 15, 15), color.Palette{color.Transparent, color.NRGBA{10, 200, 30, 128}, color.White})
+	for i := range paletted.Pix {
+		paletted.Pix[i] = uint8(rnd.Intn(3))
+	}
+	return append(images, alpha, image.NewAlpha(image.Rect(0, 0, 5, 3)), gray, paletted)
+}
+
+// 往返测试 - webp解码后不透明像素和原图相同，jpg尺寸正确且画质不低于标准库
+func TestRoundTrip(t *testing.T) {
+	for i, src := range roundTripImages() {
+		b := src.Bounds()
+		buf := new(bytes.Buffer)
+		if err := EncodeWebP(buf, src); err != nil {
+			t.Fatalf("image %d %T %v webp: %v", i, src, b, err)
+		}
+		img, err := webp.Decode(buf)
+		if err != nil {
+			t.Fatalf("image %d %T %v webp decode: %v", i, src, b, err)
+		}
+		if img.Bounds().Size() != b.Size() {
+			t.Fatalf("image %d webp size got %v want %v", i, img.Bounds().Size(), b.Size())
+		}
+		for y := 0; y < b.Dy(); y++ {
+			for x := 0; x < b.Dx(); x++ {
+				want := color.NRGBAModel.Convert(src.At(b.Min.X+x, b.Min.Y+y)).(color.NRGBA)
+				got := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+				if want.A == 0 {
+					got, want = color.NRGBA{A: got.A}, color.NRGBA{}
+				}
+				if got != want {
+					t.Fatalf("image %d %T pixel (%d,%d) got %v want %v", i, src, x, y, got, want)
+				}
+			}
+		}
+
+		std, prog := new(bytes.Buffer), new(bytes.Buffer)
+		if err = jpeg.Encode(std, src, &jpeg.Options{Quality: 90}); err != nil {
+			t.Fatal(err)
+		}
+		if err = EncodeProgressiveJPEG(prog, src, 90); err != nil {
+			t.Fatalf("image %d %T %v jpeg: %v", i, src, b, err)
+		}
+		stdImg, err := jpeg.Decode(std)
+		if err != nil {
+			t.Fatal(err)
+		}
+		progImg, err := jpeg.Decode(prog)
+		if err != nil {
+			t.Fatalf("image %d %T %v jpeg decode: %v", i, src, b, err)
+		}
+		if progImg.Bounds() != stdImg.Bounds() {
+			t.Fatalf("image %d jpeg bounds got %v want %v", i, progImg.Bounds(), stdImg.Bounds())
+		}
+		if want, got := psnr(stdImg, src), psnr(progImg, src); got < want-1 {
+			t.Fatalf("image %d %T %v jpeg PSNR got %.2f dB, image/jpeg %.2f dB", i, src, b, got, want)
+		}
+	}
+}
+
+// psnr 两张图片RGB的峰值信噪比 - b的起点可以和a不同
+func psnr(a, b image.Image) float64 {
+	var se float64
+	n := 0
+	r, off := a.Bounds(), b.Bounds().Min.Sub(a.Bounds().Min)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			r1, g1, b1, _ := a.At(x, y).RGBA()
+			r2, g2, b2, _ := b.At(x+off.X, y+off.Y).RGBA()
+			for _, d := range []float64{float64(r1>>8) - float64(r2>>8), float64(g1>>8) - float64(g2>>8), float64(b1>>8) - float64(b2>>8)} {
+				se += d * d
+				n++
+			}
+		}
+	}
+	if se == 0 {
+		return math.Inf(1)
+	}
+	return 10 * math.Log10(255*255/(se/float64(n)))
+}
+
+func diff(a uint32, b uint8) uint32 {
+	if a > uint32(b) {
+		return a - uint32(b)
+	}
+	return uint32(b) - a
+}
@@ -0,0 +1,474 @@
+// Package encoder 标准库不支持的图片编码 - 渐进式jpg、无损webp
+package encoder
+
+import (
+	"bufio"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"math"
+)
+
+// DefaultQuality 默认jpg质量
+const DefaultQuality = 75
+
+// unscaledQuant 未缩放的亮度、色度量化表，zig-zag顺序
+var unscaledQuant = [2][64]byte{
+	{
+		16, 11, 12, 14, 12, 10, 16, 14,
+		13, 14, 18, 17, 16, 19, 24, 40,
+		26, 24, 22, 22, 24, 49, 35, 37,
+		29, 40, 58, 51, 61, 60, 57, 51,
+		56, 55, 64, 72, 92, 78, 64, 68,
+		87, 69, 55, 56, 80, 109, 81, 87,
+		95, 98, 103, 104, 103, 62, 77, 113,
+		121, 112, 100, 120, 92, 101, 103, 99,
+	},
+	{
+		17, 18, 18, 24, 21, 24, 47, 26,
+		26, 47, 99, 66, 56, 66, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+	},
+}
+
+// unzig zig-zag顺序到自然顺序的下标
+var unzig = [64]int{
+	0, 1, 8, 16, 9, 2, 3, 10,
+	17, 24, 32, 25, 18, 11, 4, 5,
+	12, 19, 26, 33, 40, 48, 41, 34,
+	27, 20, 13, 6, 7, 14, 21, 28,
+	35, 42, 49, 56, 57, 50, 43, 36,
+	29, 22, 15, 23, 30, 37, 44, 51,
+	58, 59, 52, 45, 38, 31, 39, 46,
+	53, 60, 61, 54, 47, 55, 62, 63,
+}
+
+// huffmanSpec 标准huffman表 - 每种码长的数量和按码长排列的符号
+type huffmanSpec struct {
+	count [16]byte
+	value []byte
+}
+
+// 亮度DC、亮度AC、色度DC、色度AC标准huffman表
+// 渐进式扫描只使用EOB0(0x00)和ZRL(0xf0)，标准AC表中都包含
+var huffmanSpecs = [4]huffmanSpec{
+	{
+		[16]byte{0, 1, 5, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0},
+		[]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	},
+	{
+		[16]byte{0, 2, 1, 3, 3, 2, 4, 3, 5, 5, 4, 4, 0, 0, 1, 125},
+		[]byte{
+			0x01, 0x02, 0x03, 0x00, 0x04, 0x11, 0x05, 0x12,
+			0x21, 0x31, 0x41, 0x06, 0x13, 0x51, 0x61, 0x07,
+			0x22, 0x71, 0x14, 0x32, 0x81, 0x91, 0xa1, 0x08,
+			0x23, 0x42, 0xb1, 0xc1, 0x15, 0x52, 0xd1, 0xf0,
+			0x24, 0x33, 0x62, 0x72, 0x82, 0x09, 0x0a, 0x16,
+			0x17, 0x18, 0x19, 0x1a, 0x25, 0x26, 0x27, 0x28,
+			0x29, 0x2a, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39,
+			0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48, 0x49,
+			0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59,
+			0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69,
+			0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79,
+			0x7a, 0x83, 0x84, 0x85, 0x86, 0x87, 0x88, 0x89,
+			0x8a, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98,
+			0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7,
+			0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6,
+			0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3, 0xc4, 0xc5,
+			0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2, 0xd3, 0xd4,
+			0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda, 0xe1, 0xe2,
+			0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea,
+			0xf1, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	},
+	{
+		[16]byte{0, 3, 1, 1, 1, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0},
+		[]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	},
+	{
+		[16]byte{0, 2, 1, 2, 4, 4, 3, 4, 7, 5, 4, 4, 0, 1, 2, 119},
+		[]byte{
+			0x00, 0x01, 0x02, 0x03, 0x11, 0x04, 0x05, 0x21,
+			0x31, 0x06, 0x12, 0x41, 0x51, 0x07, 0x61, 0x71,
+			0x13, 0x22, 0x32, 0x81, 0x08, 0x14, 0x42, 0x91,
+			0xa1, 0xb1, 0xc1, 0x09, 0x23, 0x33, 0x52, 0xf0,
+			0x15, 0x62, 0x72, 0xd1, 0x0a, 0x16, 0x24, 0x34,
+			0xe1, 0x25, 0xf1, 0x17, 0x18, 0x19, 0x1a, 0x26,
+			0x27, 0x28, 0x29, 0x2a, 0x35, 0x36, 0x37, 0x38,
+			0x39, 0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48,
+			0x49, 0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58,
+			0x59, 0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68,
+			0x69, 0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78,
+			0x79, 0x7a, 0x82, 0x83, 0x84, 0x85, 0x86, 0x87,
+			0x88, 0x89, 0x8a, 0x92, 0x93, 0x94, 0x95, 0x96,
+			0x97, 0x98, 0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5,
+			0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4,
+			0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3,
+			0xc4, 0xc5, 0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2,
+			0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda,
+			0xe2, 0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9,
+			0xea, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	},
+}
+
+// huffmanCode 符号对应的码字和码长
+type huffmanCode struct {
+	code uint32
+	size uint32
+}
+
+// newHuffmanCodes 根据标准表生成码字
+func newHuffmanCodes(spec huffmanSpec) (codes [256]huffmanCode) {
+	code, k := uint32(0), 0
+	for i, n := range spec.count {
+		for j := 0; j < int(n); j++ {
+			codes[spec.value[k]] = huffmanCode{code: code, size: uint32(i + 1)}
+			code++
+			k++
+		}
+		code <<= 1
+	}
+	return
+}
+
+// dctCos DCT余弦表 dctCos[x][u] = C(u)/2 * cos((2x+1)uπ/16)
+var dctCos = func() (t [8][8]float64) {
+	for x := 0; x < 8; x++ {
+		for u := 0; u < 8; u++ {
+			c := 0.5
+			if u == 0 {
+				c = 0.5 / math.Sqrt2
+			}
+			t[x][u] = c * math.Cos(float64(2*x+1)*float64(u)*math.Pi/16)
+		}
+	}
+	return
+}()
+
+// jpegComponent 一个颜色分量的采样值和量化后的系数块
+type jpegComponent struct {
+	id     byte
+	h, v   byte // 采样因子
+	table  int  // 量化表和huffman表下标 0:亮度 1:色度
+	width  int
+	height int
+	raw    [][64]float64 // 电平偏移后的采样值块
+	blocks [][64]int32   // 量化后的系数块，自然顺序
+	bw, bh int           // 每行、每列的块数
+}
+
+// jpegWriter 写入jpg数据 - 熵编码数据中的0xff后补0x00
+type jpegWriter struct {
+	w     *bufio.Writer
+	bits  uint32
+	nBits uint32
+	err   error
+}
+
+func (jw *jpegWriter) write(p []byte) {
+	if jw.err != nil {
+		return
+	}
+	_, jw.err = jw.w.Write(p)
+}
+
+func (jw *jpegWriter) writeMarker(marker byte, data []byte) {
+	jw.write([]byte{0xff, marker, byte((len(data) + 2) >> 8), byte(len(data) + 2)})
+	jw.write(data)
+}
+
+func (jw *jpegWriter) emit(bits, n uint32) {
+	if n == 0 {
+		return
+	}
+	bits &= 1<<n - 1
+	jw.bits = jw.bits<<n | bits
+	jw.nBits += n
+	for jw.nBits >= 8 {
+		b := byte(jw.bits >> (jw.nBits - 8))
+		jw.nBits -= 8
+		if jw.err == nil {
+			jw.err = jw.w.WriteByte(b)
+			if b == 0xff && jw.err == nil {
+				jw.err = jw.w.WriteByte(0)
+			}
+		}
+	}
+}
+
+// flush 用1补齐最后一个字节
+func (jw *jpegWriter) flush() {
+	if jw.nBits > 0 {
+		jw.emit(0x7f, 8-jw.nBits)
+	}
+	jw.bits, jw.nBits = 0, 0
+}
+
+// emitValue 写入huffman符号和数值的附加位
+func (jw *jpegWriter) emitValue(codes *[256]huffmanCode, run uint32, value int32) {
+	size, bits := valueBits(value)
+	c := codes[run<<4|size]
+	jw.emit(c.code, c.size)
+	jw.emit(bits, size)
+}
+
+// valueBits 数值的位数和附加位 - 负数为反码
+func valueBits(value int32) (size, bits uint32) {
+	a := value
+	if a < 0 {
+		a = -a
+		value--
+	}
+	for a > 0 {
+		size++
+		a >>= 1
+	}
+	return size, uint32(value)
+}
+
+// jpegScan 渐进式扫描 - 只使用频谱选择，不使用逐次逼近，量化后的系数和基线jpg相同，画质由量化表决定
+type jpegScan struct {
+	comp   int
+	ss, se int
+}
+
+// progressiveScans 先传各分量的DC，再传亮度低频、色度、亮度高频
+var progressiveScans = []jpegScan{
+	{0, 0, 0}, {1, 0, 0}, {2, 0, 0},
+	{0, 1, 5}, {2, 1, 63}, {1, 1, 63}, {0, 6, 63},
+}
+
+// EncodeProgressiveJPEG 编码为渐进式jpg - 色度2x2采样，quality为1-100，为0时使用默认质量
+func EncodeProgressiveJPEG(w io.Writer, m image.Image, quality int) error {
+	b := m.Bounds()
+	if b.Dx() <= 0 || b.Dy() <= 0 || b.Dx() >= 1<<16 || b.Dy() >= 1<<16 {
+		return errors.New("The image size is not supported by jpeg")
+	}
+	if quality == 0 {
+		quality = DefaultQuality
+	}
+	if quality < 1 {
+		quality = 1
+	} else if quality > 100 {
+		quality = 100
+	}
+	scale := 200 - quality*2
+	if quality < 50 {
+		scale = 5000 / quality
+	}
+	var quant [2][64]byte
+	for i := range quant {
+		for j := range quant[i] {
+			x := (int(unscaledQuant[i][j])*scale + 50) / 100
+			if x < 1 {
+				x = 1
+			} else if x > 255 {
+				x = 255
+			}
+			quant[i][j] = byte(x)
+		}
+	}
+
+	comps := splitYCbCr(m)
+	for _, c := range comps {
+		c.transform(&quant[c.table])
+	}
+
+	jw := &jpegWriter{w: bufio.NewWriter(w)}
+	jw.write([]byte{0xff, 0xd8})
+	// 量化表
+	dqt := make([]byte, 0, 2*65)
+	for i := range quant {
+		dqt = append(dqt, byte(i))
+		dqt = append(dqt, quant[i][:]...)
+	}
+	jw.writeMarker(0xdb, dqt)
+	// 帧头 SOF2
+	sof := []byte{8, byte(b.Dy() >> 8), byte(b.Dy()), byte(b.Dx() >> 8), byte(b.Dx()), byte(len(comps))}
+	for _, c := range comps {
+		sof = append(sof, c.id, c.h<<4|c.v, byte(c.table))
+	}
+	jw.writeMarker(0xc2, sof)
+	// huffman表 - 类型(0:DC 1:AC)<<4 | 下标
+	var dht []byte
+	var codes [4][256]huffmanCode
+	for i, spec := range huffmanSpecs {
+		dht = append(dht, byte(i%2)<<4|byte(i/2))
+		dht = append(dht, spec.count[:]...)
+		dht = append(dht, spec.value...)
+		codes[i] = newHuffmanCodes(spec)
+	}
+	jw.writeMarker(0xc4, dht)
+
+	for _, scan := range progressiveScans {
+		c := comps[scan.comp]
+		jw.writeMarker(0xda, []byte{1, c.id, byte(c.table<<4 | c.table), byte(scan.ss), byte(scan.se), 0})
+		if scan.ss == 0 {
+			c.writeDC(jw, &codes[c.table*2])
+		} else {
+			c.writeAC(jw, &codes[c.table*2+1], scan.ss, scan.se)
+		}
+		jw.flush()
+	}
+	jw.write([]byte{0xff, 0xd9})
+	if jw.err != nil {
+		return jw.err
+	}
+	return jw.w.Flush()
+}
+
+// splitYCbCr 转换为Y、Cb、Cr分量，色度宽高各为亮度的一半
+// YCbCr图片直接使用原分量，其他图片按浮点数转换，避免先转为8位RGB再转换带来的精度损失
+func splitYCbCr(m image.Image) []*jpegComponent {
+	b := m.Bounds()
+	w, h := b.Dx(), b.Dy()
+	cw, ch := (w+1)/2, (h+1)/2
+	y := &jpegComponent{id: 1, h: 2, v: 2, table: 0, width: w, height: h}
+	cb := &jpegComponent{id: 2, h: 1, v: 1, table: 1, width: cw, height: ch}
+	cr := &jpegComponent{id: 3, h: 1, v: 1, table: 1, width: cw, height: ch}
+	ys := make([]float64, w*h)
+	cbs := make([]float64, cw*ch)
+	crs := make([]float64, cw*ch)
+	counts := make([]float64, cw*ch)
+	src, isYCbCr := m.(*image.YCbCr)
+	for j := 0; j < h; j++ {
+		for i := 0; i < w; i++ {
+			var yy, u, v float64
+			if isYCbCr == true {
+				x, y := b.Min.X+i, b.Min.Y+j
+				yy = float64(src.Y[src.YOffset(x, y)])
+				u = float64(src.Cb[src.COffset(x, y)])
+				v = float64(src.Cr[src.COffset(x, y)])
+			} else {
+				yy, u, v = rgbToYCbCr(m.At(b.Min.X+i, b.Min.Y+j))
+			}
+			ys[j*w+i] = yy
+			k := (j/2)*cw + i/2
+			cbs[k] += u
+			crs[k] += v
+			counts[k]++
+		}
+	}
+	for k := range counts {
+		cbs[k] /= counts[k]
+		crs[k] /= counts[k]
+	}
+	y.samples(ys)
+	cb.samples(cbs)
+	cr.samples(crs)
+	return []*jpegComponent{y, cb, cr}
+}
+
+// rgbToYCbCr JFIF颜色转换 - 使用16位颜色值，结果不取整
+func rgbToYCbCr(c color.Color) (y, cb, cr float64) {
+	r16, g16, b16, _ := c.RGBA()
+	r, g, b := float64(r16)/257, float64(g16)/257, float64(b16)/257
+	y = 0.299*r + 0.587*g + 0.114*b
+	cb = -0.168736*r - 0.331264*g + 0.5*b + 128
+	cr = 0.5*r - 0.418688*g - 0.081312*b + 128
+	return
+}
+
+// samples 将采样值分成8x8的块，不足8的部分重复边缘像素，块中暂存电平偏移后的采样值
+func (c *jpegComponent) samples(s []float64) {
+	c.bw, c.bh = (c.width+7)/8, (c.height+7)/8
+	c.blocks = make([][64]int32, c.bw*c.bh)
+	c.raw = make([][64]float64, c.bw*c.bh)
+	for by := 0; by < c.bh; by++ {
+		for bx := 0; bx < c.bw; bx++ {
+			blk := &c.raw[by*c.bw+bx]
+			for y := 0; y < 8; y++ {
+				sy := by*8 + y
+				if sy >= c.height {
+					sy = c.height - 1
+				}
+				for x := 0; x < 8; x++ {
+					sx := bx*8 + x
+					if sx >= c.width {
+						sx = c.width - 1
+					}
+					blk[y*8+x] = s[sy*c.width+sx] - 128
+				}
+			}
+		}
+	}
+}
+
+// transform 对每个块做DCT并量化
+func (c *jpegComponent) transform(quant *[64]byte) {
+	var tmp, coef [64]float64
+	for i := range c.raw {
+		src := &c.raw[i]
+		// 先按行再按列做一维DCT
+		for y := 0; y < 8; y++ {
+			for u := 0; u < 8; u++ {
+				sum := 0.0
+				for x := 0; x < 8; x++ {
+					sum += src[y*8+x] * dctCos[x][u]
+				}
+				tmp[y*8+u] = sum
+			}
+		}
+		for u := 0; u < 8; u++ {
+			for v := 0; v < 8; v++ {
+				sum := 0.0
+				for y := 0; y < 8; y++ {
+					sum += tmp[y*8+u] * dctCos[y][v]
+				}
+				coef[v*8+u] = sum
+			}
+		}
+		// 量化表为zig-zag顺序，8位精度时DC在11位以内，AC在10位以内，只有质量100时极端的AC才会被限制
+		for k := 0; k < 64; k++ {
+			n := unzig[k]
+			limit := 1023.0
+			if k == 0 {
+				limit = 2047
+			}
+			c.blocks[i][n] = int32(math.Max(-limit, math.Min(limit, math.Round(coef[n]/float64(quant[k])))))
+		}
+	}
+	c.raw = nil
+}
+
+// writeDC DC首次扫描 - 单分量扫描按块的行顺序写入与前一块DC的差值
+func (c *jpegComponent) writeDC(jw *jpegWriter, codes *[256]huffmanCode) {
+	prev := int32(0)
+	for i := range c.blocks {
+		dc := c.blocks[i][0]
+		jw.emitValue(codes, 0, dc-prev)
+		prev = dc
+	}
+}
+
+// writeAC AC首次扫描 - 写入ss到se的系数，剩余全为0时写EOB0
+func (c *jpegComponent) writeAC(jw *jpegWriter, codes *[256]huffmanCode, ss, se int) {
+	for i := range c.blocks {
+		run := uint32(0)
+		for k := ss; k <= se; k++ {
+			ac := c.blocks[i][unzig[k]]
+			if ac == 0 {
+				run++
+				continue
+			}
+			for run > 15 {
+				jw.emit(codes[0xf0].code, codes[0xf0].size)
+				run -= 16
+			}
+			jw.emitValue(codes, run, ac)
+			run = 0
+		}
+		if run > 0 {
+			jw.emit(codes[0x00].code, codes[0x00].size)
+		}
+	}
+}
@@ -0,0 +1,414 @@
+package encoder
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+	"io"
+	"math/bits"
+)
+
+// 无损webp(VP8L) - 使用减绿变换和向左、向上的重复像素引用，不使用颜色缓存和预测变换
+
+const (
+	webpMaxSize      = 1 << 14 // 宽高最大值
+	webpMaxCopy      = 4096    // 一次引用的最大像素数
+	webpMinCopy      = 3       // 少于该长度时直接写像素
+	webpGreenSize    = 256 + 24
+	webpDistanceSize = 40
+)
+
+// 距离码 - 120以内为相对当前像素的平面位置，1为正上方，2为左边
+const (
+	webpDistanceUp   = 1
+	webpDistanceLeft = 2
+)
+
+// codeLengthOrder 码长编码的码长写入顺序
+var codeLengthOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// webpToken 一个像素或一段重复像素的引用
+type webpToken struct {
+	argb     uint32
+	length   int // 大于0时为引用
+	distance int // 距离码
+}
+
+// bitWriter 低位在前写入
+type bitWriter struct {
+	buf   []byte
+	bits  uint64
+	nBits uint
+}
+
+func (bw *bitWriter) write(v uint32, n uint) {
+	bw.bits |= uint64(v) << bw.nBits
+	bw.nBits += n
+	for bw.nBits >= 8 {
+		bw.buf = append(bw.buf, byte(bw.bits))
+		bw.bits >>= 8
+		bw.nBits -= 8
+	}
+}
+
+func (bw *bitWriter) flush() {
+	if bw.nBits > 0 {
+		bw.buf = append(bw.buf, byte(bw.bits))
+	}
+	bw.bits, bw.nBits = 0, 0
+}
+
+// prefixCode 长度和距离的前缀码、附加位数和附加位
+func prefixCode(v int) (code int, extraBits uint, extra uint32) {
+	v--
+	if v < 4 {
+		return v, 0, 0
+	}
+	h := bits.Len(uint(v)) - 1
+	second := (v >> uint(h-1)) & 1
+	extraBits = uint(h - 1)
+	return 2*h + second, extraBits, uint32(v) & (1<<extraBits - 1)
+}
+
+// prefixCodes 一个字母表的huffman码长和按写入顺序反转的码字
+type prefixCodes struct {
+	lengths []uint8
+	codes   []uint32
+	simple  bool // 只有一个符号时使用简单编码，不占用位
+	symbol  int
+}
+
+// newPrefixCodes 根据频率生成码长不超过limit的huffman编码
+func newPrefixCodes(hist []uint32, limit int) *prefixCodes {
+	pc := &prefixCodes{}
+	used := 0
+	for i, n := range hist {
+		if n > 0 {
+			used++
+			pc.symbol = i
+		}
+	}
+	if used <= 1 && pc.symbol < 256 {
+		pc.simple = true
+		return pc
+	}
+	pc.lengths = huffmanLengths(hist, limit)
+	pc.codes = canonicalCodes(pc.lengths)
+	return pc
+}
+
+// writeSymbol 写入符号
+func (pc *prefixCodes) writeSymbol(bw *bitWriter, symbol int) {
+	if pc.simple == true {
+		return
+	}
+	bw.write(pc.codes[symbol], uint(pc.lengths[symbol]))
+}
+
+// writeCode 写入huffman编码本身
+func (pc *prefixCodes) writeCode(bw *bitWriter) {
+	if pc.simple == true {
+		// 简单编码 1个符号
+		bw.write(1, 1)
+		bw.write(0, 1)
+		if pc.symbol < 2 {
+			bw.write(0, 1)
+			bw.write(uint32(pc.symbol), 1)
+		} else {
+			bw.write(1, 1)
+			bw.write(uint32(pc.symbol), 8)
+		}
+		return
+	}
+	bw.write(0, 1)
+	// 码长序列 - 连续的0使用17、18重复编码
+	type lengthToken struct {
+		symbol    int
+		extra     uint32
+		extraBits uint
+	}
+	var tokens []lengthToken
+	hist := make([]uint32, 19)
+	for i := 0; i < len(pc.lengths); {
+		if pc.lengths[i] != 0 {
+			tokens = append(tokens, lengthToken{symbol: int(pc.lengths[i])})
+			hist[pc.lengths[i]]++
+			i++
+			continue
+		}
+		run := 0
+		for i+run < len(pc.lengths) && pc.lengths[i+run] == 0 {
+			run++
+		}
+		i += run
+		for run >= 11 {
+			n := run
+			if n > 138 {
+				n = 138
+			}
+			tokens = append(tokens, lengthToken{18, uint32(n - 11), 7})
+			hist[18]++
+			run -= n
+		}
+		if run >= 3 {
+			tokens = append(tokens, lengthToken{17, uint32(run - 3), 3})
+			hist[17]++
+			run = 0
+		}
+		for ; run > 0; run-- {
+			tokens = append(tokens, lengthToken{symbol: 0})
+			hist[0]++
+		}
+	}
+	lengths := huffmanLengths(hist, 7)
+	codes := canonicalCodes(lengths)
+	n := 4
+	for i, sym := range codeLengthOrder {
+		if lengths[sym] != 0 && i+1 > n {
+			n = i + 1
+		}
+	}
+	bw.write(uint32(n-4), 4)
+	for _, sym := range codeLengthOrder[:n] {
+		bw.write(uint32(lengths[sym]), 3)
+	}
+	// 使用整个字母表
+	bw.write(0, 1)
+	for _, t := range tokens {
+		bw.write(codes[t.symbol], uint(lengths[t.symbol]))
+		bw.write(t.extra, t.extraBits)
+	}
+}
+
+// huffmanLengths 计算huffman码长 - 超过limit时抬高小频率重新计算，至少两个符号有码长
+func huffmanLengths(hist []uint32, limit int) []uint8 {
+	weights := make([]uint64, len(hist))
+	used := 0
+	for i, n := range hist {
+		weights[i] = uint64(n)
+		if n > 0 {
+			used++
+		}
+	}
+	for i := 0; used < 2; i++ {
+		if weights[i] == 0 {
+			weights[i] = 1
+			used++
+		}
+	}
+	for minWeight := uint64(1); ; minWeight *= 2 {
+		w := make([]uint64, len(weights))
+		for i, n := range weights {
+			if n > 0 && n < minWeight {
+				n = minWeight
+			}
+			w[i] = n
+		}
+		lengths, maxLength := treeLengths(w)
+		if maxLength <= limit {
+			return lengths
+		}
+	}
+}
+
+// treeLengths 构建huffman树，返回每个符号的深度
+func treeLengths(weights []uint64) ([]uint8, int) {
+	type node struct {
+		weight uint64
+		parent int
+	}
+	var nodes []node
+	var active []int
+	leaf := make([]int, len(weights))
+	for i, w := range weights {
+		leaf[i] = -1
+		if w > 0 {
+			leaf[i] = len(nodes)
+			active = append(active, len(nodes))
+			nodes = append(nodes, node{weight: w, parent: -1})
+		}
+	}
+	smallest := func() int {
+		k := 0
+		for i := range active {
+			if nodes[active[i]].weight < nodes[active[k]].weight {
+				k = i
+			}
+		}
+		n := active[k]
+		active = append(active[:k], active[k+1:]...)
+		return n
+	}
+	for len(active) > 1 {
+		a, b := smallest(), smallest()
+		parent := len(nodes)
+		nodes = append(nodes, node{weight: nodes[a].weight + nodes[b].weight, parent: -1})
+		nodes[a].parent, nodes[b].parent = parent, parent
+		active = append(active, parent)
+	}
+	lengths := make([]uint8, len(weights))
+	maxLength := 0
+	for i, n := range leaf {
+		if n < 0 {
+			continue
+		}
+		depth := 0
+		for p := nodes[n].parent; p >= 0; p = nodes[p].parent {
+			depth++
+		}
+		lengths[i] = uint8(depth)
+		if depth > maxLength {
+			maxLength = depth
+		}
+	}
+	return lengths, maxLength
+}
+
+// canonicalCodes 按码长生成规范huffman码字，并反转为低位在前的写入顺序
+func canonicalCodes(lengths []uint8) []uint32 {
+	var count [16]uint32
+	for _, l := range lengths {
+		count[l]++
+	}
+	count[0] = 0
+	var next [16]uint32
+	code := uint32(0)
+	for l := 1; l < 16; l++ {
+		code = (code + count[l-1]) << 1
+		next[l] = code
+	}
+	codes := make([]uint32, len(lengths))
+	for i, l := range lengths {
+		if l == 0 {
+			continue
+		}
+		codes[i] = bits.Reverse32(next[l]) >> (32 - uint(l))
+		next[l]++
+	}
+	return codes
+}
+
+// webpTokens 将像素转为像素和引用序列 - 与左边或上方像素相同的连续像素使用引用
+func webpTokens(argb []uint32, width int) []webpToken {
+	var tokens []webpToken
+	for i := 0; i < len(argb); {
+		best, distance := 0, 0
+		for _, c := range [2]struct{ offset, distance int }{{1, webpDistanceLeft}, {width, webpDistanceUp}} {
+			if i < c.offset {
+				continue
+			}
+			n := 0
+			for i+n < len(argb) && n < webpMaxCopy && argb[i+n] == argb[i+n-c.offset] {
+				n++
+			}
+			if n > best {
+				best, distance = n, c.distance
+			}
+		}
+		if best >= webpMinCopy {
+			tokens = append(tokens, webpToken{length: best, distance: distance})
+			i += best
+			continue
+		}
+		tokens = append(tokens, webpToken{argb: argb[i]})
+		i++
+	}
+	return tokens
+}
+
+// EncodeWebP 编码为无损webp
+func EncodeWebP(w io.Writer, m image.Image) error {
+	b := m.Bounds()
+	width, height := b.Dx(), b.Dy()
+	if width <= 0 || height <= 0 || width > webpMaxSize || height > webpMaxSize {
+		return errors.New("The image size is not supported by webp")
+	}
+	nrgba := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(nrgba, nrgba.Bounds(), m, b.Min, draw.Src)
+	argb := make([]uint32, width*height)
+	alphaUsed := uint32(0)
+	for i := range argb {
+		p := nrgba.Pix[i*4 : i*4+4]
+		r, g, bl, a := uint32(p[0]), uint32(p[1]), uint32(p[2]), uint32(p[3])
+		if a != 0xff {
+			alphaUsed = 1
+		}
+		// 减绿变换
+		r, bl = (r-g)&0xff, (bl-g)&0xff
+		argb[i] = a<<24 | r<<16 | g<<8 | bl
+	}
+
+	tokens := webpTokens(argb, width)
+	hists := [5][]uint32{
+		make([]uint32, webpGreenSize), make([]uint32, 256), make([]uint32, 256),
+		make([]uint32, 256), make([]uint32, webpDistanceSize),
+	}
+	for _, t := range tokens {
+		if t.length > 0 {
+			code, _, _ := prefixCode(t.length)
+			hists[0][256+code]++
+			code, _, _ = prefixCode(t.distance)
+			hists[4][code]++
+			continue
+		}
+		hists[0][t.argb>>8&0xff]++
+		hists[1][t.argb>>16&0xff]++
+		hists[2][t.argb&0xff]++
+		hists[3][t.argb>>24]++
+	}
+	var codes [5]*prefixCodes
+	for i := range hists {
+		codes[i] = newPrefixCodes(hists[i], 15)
+	}
+
+	bw := &bitWriter{}
+	bw.write(0x2f, 8)
+	bw.write(uint32(width-1), 14)
+	bw.write(uint32(height-1), 14)
+	bw.write(alphaUsed, 1)
+	bw.write(0, 3)
+	// 变换 - 只有减绿变换
+	bw.write(1, 1)
+	bw.write(2, 2)
+	bw.write(0, 1)
+	// 不使用颜色缓存和多组编码
+	bw.write(0, 1)
+	bw.write(0, 1)
+	for _, c := range codes {
+		c.writeCode(bw)
+	}
+	for _, t := range tokens {
+		if t.length > 0 {
+			code, extraBits, extra := prefixCode(t.length)
+			codes[0].writeSymbol(bw, 256+code)
+			bw.write(extra, extraBits)
+			code, extraBits, extra = prefixCode(t.distance)
+			codes[4].writeSymbol(bw, code)
+			bw.write(extra, extraBits)
+			continue
+		}
+		codes[0].writeSymbol(bw, int(t.argb>>8&0xff))
+		codes[1].writeSymbol(bw, int(t.argb>>16&0xff))
+		codes[2].writeSymbol(bw, int(t.argb&0xff))
+		codes[3].writeSymbol(bw, int(t.argb>>24))
+	}
+	bw.flush()
+
+	// RIFF容器
+	data := bw.buf
+	pad := len(data) % 2
+	header := make([]byte, 20)
+	copy(header[0:4], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:8], uint32(4+8+len(data)+pad))
+	copy(header[8:16], "WEBPVP8L")
+	binary.LittleEndian.PutUint32(header[16:20], uint32(len(data)))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if pad == 1 {
+		data = append(data, 0)
+	}
+	_, err := w.Write(data)
+	return err
+}
@@ -6,6 +6,8 @@ import (
 
 	"github.com/shiguanghuxian/poster/program/config"
 	"github.com/shiguanghuxian/poster/program/logger"
+	"github.com/shiguanghuxian/poster/program/service"
+	"github.com/shiguanghuxian/poster/program/template"
 	"github.com/shiguanghuxian/poster/program/transport"
 )
 
@@ -28,6 +30,24 @@ func New() (*Program, error) {
 		return nil, err
 	}
 
+	// 扫描字体 - 失败时继续启动，使用文本的请求返回找不到字体的错误
+	err = service.LoadFonts(cfg.ResourcesDir)
+	if err != nil {
+		logger.Log.Warnw("加载字体错误，文本将无法绘制", "err", err, "dir", cfg.ResourcesDir)
+	}
+
+	// 加载通过接口保存的模板
+	err = template.Default().LoadFile(cfg.TemplatesStore)
+	if err != nil {
+		return nil, err
+	}
+
+	// 加载模板目录并监听变化
+	err = template.Default().LoadDir(cfg.TemplatesDir)
+	if err != nil {
+		return nil, err
+	}
+
 	// jj, _ := json.Marshal(cfg)
 	// fmt.Println(string(jj))
 
@@ -65,6 +85,7 @@ func (p *Program) Run() error {
 
 // Stop 停止服务
 func (p *Program) Stop() {
+	template.Default().Close()
 	logger.Log.Sync()
 }
 
@@ -0,0 +1,70 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+	"golang.org/x/image/webp"
+)
+
+// imageDecoders 按图片格式类型解码 - 根据内容无法识别格式时使用image_type指定的解码器
+// 导入这些包的同时会向image注册解码器，image.Decode可以根据内容识别格式，gif只取第一帧
+var imageDecoders = map[string]func(io.Reader) (image.Image, error){
+	"jpg":  jpeg.Decode,
+	"jpeg": jpeg.Decode,
+	"png":  png.Decode,
+	"gif":  gif.Decode,
+	"bmp":  bmp.Decode,
+	"tif":  tiff.Decode,
+	"tiff": tiff.Decode,
+	"webp": webp.Decode,
+}
+
+// checkImageType 检查图片格式类型 - 可为空，不区分大小写
+func checkImageType(imageType *string) (err error) {
+	*imageType = strings.TrimPrefix(strings.ToLower(*imageType), ".")
+	if *imageType == "" {
+		return
+	}
+	if _, ok := imageDecoders[*imageType]; ok == false {
+		return errors.New("Unsupported image types -- " + *imageType)
+	}
+	return
+}
+
+// decodeImage 解析图片 - 根据图片内容识别格式，识别失败时按imageType解码
+// jpg图片按EXIF方向转正，手机拍摄的竖版照片不会横过来
+func decodeImage(r io.Reader, imageType string) (img image.Image, format string, err error) {
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		return
+	}
+	img, format, err = image.Decode(bytes.NewReader(body))
+	if err == image.ErrFormat {
+		imageType = strings.ToLower(imageType)
+		decode, ok := imageDecoders[imageType]
+		if ok == false {
+			err = fmt.Errorf("Unknown image format, supported formats are jpg, png, gif, bmp, tiff and webp")
+			return
+		}
+		img, err = decode(bytes.NewReader(body))
+		format = imageType
+	}
+	if err != nil {
+		return
+	}
+	if format == "jpeg" || format == "jpg" {
+		img = applyOrientation(img, exifOrientation(body))
+	}
+	return
+}
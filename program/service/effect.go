@@ -0,0 +1,213 @@
+package service
+
+import (
+	"image"
+	"image/draw"
+	"math"
+
+	"github.com/fogleman/gg"
+	"github.com/shiguanghuxian/poster/program/common"
+)
+
+// 文字描边、阴影和背景框
+
+// effectMargin 描边和阴影超出文字区域的最大距离
+func (txt *Text) effectMargin() (margin int) {
+	if txt.Stroke != nil {
+		margin += int(math.Ceil(txt.Stroke.Width))
+	}
+	if txt.Shadow != nil {
+		margin += txt.Shadow.Blur + 2
+	}
+	return
+}
+
+// drawTextEffects 根据文字蒙版依次绘制背景框、阴影和描边
+// lineBoxes为每行文字的区域，背景框在此基础上增加内边距
+func drawTextEffects(dst *image.RGBA, txt *Text, mask *image.Alpha, lineBoxes []image.Rectangle) (err error) {
+	// 背景框
+	if txt.Background != nil {
+		bgColor, err := common.HexToColor(txt.Background.Color)
+		if err != nil {
+			return err
+		}
+		boxes := lineBoxes
+		if txt.Background.Mode == TextBackgroundBlock {
+			block := image.Rectangle{}
+			for _, b := range lineBoxes {
+				block = block.Union(b)
+			}
+			boxes = []image.Rectangle{block}
+		}
+		dc := gg.NewContextForRGBA(dst)
+		dc.SetColor(bgColor)
+		for _, b := range boxes {
+			if b.Empty() == true {
+				continue
+			}
+			b = b.Inset(-txt.Background.Padding)
+			dc.DrawRoundedRectangle(float64(b.Min.X), float64(b.Min.Y), float64(b.Dx()), float64(b.Dy()), txt.Background.Radius)
+			dc.Fill()
+		}
+	}
+
+	// 描边和阴影只处理有文字的区域，向外扩展effectMargin
+	ink := alphaBounds(mask)
+	if ink.Empty() == true {
+		return
+	}
+	mask = mask.SubImage(ink.Inset(-txt.effectMargin()).Intersect(mask.Bounds())).(*image.Alpha)
+
+	// 描边为文字蒙版向外扩展描边宽度
+	outline := mask
+	if txt.Stroke != nil && txt.Stroke.Width > 0 {
+		outline = dilateMask(mask, txt.Stroke.Width)
+	}
+
+	// 阴影
+	if txt.Shadow != nil {
+		shadowColor, err := common.HexToColor(txt.Shadow.Color)
+		if err != nil {
+			return err
+		}
+		shadow := outline
+		if txt.Shadow.Blur > 0 {
+			shadow = blurMask(outline, txt.Shadow.Blur)
+		}
+		r := shadow.Bounds().Add(image.Pt(txt.Shadow.OffsetX, txt.Shadow.OffsetY))
+		draw.DrawMask(dst, r, image.NewUniform(shadowColor), image.ZP, shadow, shadow.Bounds().Min, draw.Over)
+	}
+
+	// 描边
+	if outline != mask {
+		strokeColor, err := common.HexToColor(txt.Stroke.Color)
+		if err != nil {
+			return err
+		}
+		draw.DrawMask(dst, outline.Bounds(), image.NewUniform(strokeColor), image.ZP, outline, outline.Bounds().Min, draw.Over)
+	}
+	return
+}
+
+// alphaBounds 蒙版中不透明部分的区域
+func alphaBounds(mask *image.Alpha) image.Rectangle {
+	b := mask.Bounds()
+	r := image.Rectangle{}
+	for y := 0; y < b.Dy(); y++ {
+		row := mask.Pix[y*mask.Stride : y*mask.Stride+b.Dx()]
+		for x, a := range row {
+			if a != 0 {
+				r = r.Union(image.Rect(b.Min.X+x, b.Min.Y+y, b.Min.X+x+1, b.Min.Y+y+1))
+			}
+		}
+	}
+	return r
+}
+
+// dilateMask 将蒙版向外扩展width像素，边缘抗锯齿
+// 只有边缘像素按描边半径向外扩散 - 周围8个像素都不透明的内部像素，其扩散范围内的每个点都能由更近的边缘像素覆盖
+func dilateMask(mask *image.Alpha, width float64) *image.Alpha {
+	type offset struct {
+		dx, dy int
+		weight float64
+	}
+	r := int(math.Ceil(width))
+	offsets := make([]offset, 0, (2*r+1)*(2*r+1))
+	for dy := -r; dy <= r; dy++ {
+		for dx := -r; dx <= r; dx++ {
+			weight := math.Min(width+0.5-math.Hypot(float64(dx), float64(dy)), 1)
+			if weight > 0 {
+				offsets = append(offsets, offset{dx, dy, weight})
+			}
+		}
+	}
+
+	b := mask.Bounds()
+	w, h := b.Dx(), b.Dy()
+	out := image.NewAlpha(b)
+	for y := 0; y < h; y++ {
+		copy(out.Pix[y*out.Stride:y*out.Stride+w], mask.Pix[y*mask.Stride:y*mask.Stride+w])
+	}
+	// interior 像素和周围8个像素都在区域内且完全不透明
+	interior := func(x, y int) bool {
+		if x < 1 || y < 1 || x >= w-1 || y >= h-1 {
+			return false
+		}
+		for dy := -1; dy <= 1; dy++ {
+			row := mask.Pix[(y+dy)*mask.Stride+x-1:]
+			if row[0] != 0xff || row[1] != 0xff || row[2] != 0xff {
+				return false
+			}
+		}
+		return true
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			a := mask.Pix[y*mask.Stride+x]
+			if a == 0 {
+				continue
+			}
+			if a == 0xff && interior(x, y) {
+				continue
+			}
+			for _, o := range offsets {
+				tx, ty := x+o.dx, y+o.dy
+				if tx < 0 || ty < 0 || tx >= w || ty >= h {
+					continue
+				}
+				if v := uint8(float64(a) * o.weight); v > out.Pix[ty*out.Stride+tx] {
+					out.Pix[ty*out.Stride+tx] = v
+				}
+			}
+		}
+	}
+	return out
+}
+
+// blurMask 模糊蒙版 - 三次盒式模糊近似高斯模糊，radius为模糊扩散的距离
+func blurMask(mask *image.Alpha, radius int) *image.Alpha {
+	b := mask.Bounds()
+	w, h := b.Dx(), b.Dy()
+	cur := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			cur[y*w+x] = float64(mask.Pix[y*mask.Stride+x])
+		}
+	}
+	tmp := make([]float64, w*h)
+	boxRadius := (radius + 2) / 3
+	for i := 0; i < 3; i++ {
+		boxBlur(cur, tmp, w, h, boxRadius, 1, w)
+		boxBlur(tmp, cur, h, w, boxRadius, w, 1)
+	}
+
+	out := image.NewAlpha(b)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			out.Pix[y*out.Stride+x] = uint8(math.Min(cur[y*w+x]+0.5, 255))
+		}
+	}
+	return out
+}
+
+// boxBlur 一维盒式模糊 - step为同一行相邻像素的间隔，lineStep为相邻行的间隔
+// 每行长度为length，共lines行，超出边界的像素按透明处理
+func boxBlur(src, dst []float64, length, lines, radius, step, lineStep int) {
+	size := float64(2*radius + 1)
+	for l := 0; l < lines; l++ {
+		base := l * lineStep
+		sum := 0.0
+		for i := 0; i <= radius && i < length; i++ {
+			sum += src[base+i*step]
+		}
+		for i := 0; i < length; i++ {
+			dst[base+i*step] = sum / size
+			if i+radius+1 < length {
+				sum += src[base+(i+radius+1)*step]
+			}
+			if i-radius >= 0 {
+				sum -= src[base+(i-radius)*step]
+			}
+		}
+	}
+}
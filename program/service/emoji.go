@@ -0,0 +1,170 @@
+package service
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nfnt/resize"
+	"github.com/shiguanghuxian/poster/program/logger"
+	"golang.org/x/image/math/fixed"
+)
+
+// 彩色表情 - 使用表情图片集绘制，图片名为码位的十六进制，多个码位用-连接，如 1f600.png、1f468-200d-1f469.png
+
+// 表情序列最多包含的码位数量
+const maxEmojiRunes = 10
+
+// emojiSet 表情图片集
+type emojiSet struct {
+	dir    string
+	files  map[string]string // 码位 -> 图片路径
+	images sync.Map          // 图片路径:大小 -> 缩放后的图片
+}
+
+var (
+	emojiSets   = new(sync.Map)
+	emojiFailed = new(sync.Map) // 目录 -> 读取失败的时间
+	// 表情图片目录读取失败后，间隔一段时间再重新读取，目录创建后无需重启服务
+	emojiRetryInterval = time.Minute
+)
+
+// getEmojiSet 获取表情图片集，目录为空或不存在时返回nil
+func getEmojiSet(dir string) *emojiSet {
+	if dir == "" {
+		return nil
+	}
+	if val, ok := emojiSets.Load(dir); ok == true {
+		return val.(*emojiSet)
+	}
+	if val, ok := emojiFailed.Load(dir); ok == true && time.Since(val.(time.Time)) < emojiRetryInterval {
+		return nil
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		logger.Log.Warnw("读取表情图片目录错误", "err", err, "dir", dir)
+		emojiFailed.Store(dir, time.Now())
+		return nil
+	}
+	emojiFailed.Delete(dir)
+	set := &emojiSet{dir: dir, files: make(map[string]string)}
+	for _, info := range infos {
+		name := strings.ToLower(info.Name())
+		if info.IsDir() == true || filepath.Ext(name) != ".png" {
+			continue
+		}
+		set.files[strings.TrimSuffix(name, ".png")] = filepath.Join(dir, info.Name())
+	}
+	emojiSets.Store(dir, set)
+	return set
+}
+
+// emojiKey 码位序列对应的图片名
+func emojiKey(runes []rune) string {
+	codes := make([]string, 0, len(runes))
+	for _, r := range runes {
+		codes = append(codes, fmt.Sprintf("%x", r))
+	}
+	return strings.Join(codes, "-")
+}
+
+// match 从runes开头查找最长的表情序列，返回图片路径和序列长度
+// 图片名中可以省略变体选择符FE0F
+func (set *emojiSet) match(runes []rune) (path string, n int) {
+	if set == nil || len(runes) == 0 || isEmojiRune(runes[0]) == false {
+		return "", 0
+	}
+	n = len(runes)
+	if n > maxEmojiRunes {
+		n = maxEmojiRunes
+	}
+	for ; n > 0; n-- {
+		seq := runes[:n]
+		if path, ok := set.files[emojiKey(seq)]; ok == true {
+			return path, n
+		}
+		stripped := make([]rune, 0, n)
+		for _, r := range seq {
+			if r != 0xFE0F {
+				stripped = append(stripped, r)
+			}
+		}
+		if path, ok := set.files[emojiKey(stripped)]; ok == true {
+			return path, n
+		}
+	}
+	return "", 0
+}
+
+// image 获取缩放到指定大小的表情图片
+func (set *emojiSet) image(path string, size int) (img image.Image, err error) {
+	key := fmt.Sprintf("%s:%d", path, size)
+	if val, ok := set.images.Load(key); ok == true {
+		return val.(image.Image), nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	src, _, err := image.Decode(f)
+	if err != nil {
+		return
+	}
+	img = resize.Resize(uint(size), uint(size), src, resize.Lanczos3)
+	set.images.Store(key, img)
+	return
+}
+
+// isEmojiRune 可能为表情序列开头的字符
+func isEmojiRune(r rune) bool {
+	return r >= 0x1F000 || // 表情符号、国旗
+		(r >= 0x2190 && r <= 0x21FF) || // 箭头
+		(r >= 0x2300 && r <= 0x23FF) || // 技术符号
+		(r >= 0x2600 && r <= 0x27BF) || // 杂项符号、装饰符号
+		(r >= 0x2B00 && r <= 0x2BFF) ||
+		r == 0x00A9 || r == 0x00AE || r == 0x203C || r == 0x2049 || r == 0x2122 ||
+		r == 0x3030 || r == 0x303D || r == 0x3297 || r == 0x3299 ||
+		r == '#' || r == '*' || (r >= '0' && r <= '9')
+}
+
+// isEmojiSequence 需要优先使用表情图片的字符 - 表情平面字符或带有变体选择符、零宽连接符的序列
+func isEmojiSequence(runes []rune) bool {
+	if len(runes) == 0 {
+		return false
+	}
+	if runes[0] >= 0x1F000 {
+		return true
+	}
+	return len(runes) > 1 && (runes[1] == 0xFE0F || runes[1] == 0x200D || runes[1] == 0x20E3)
+}
+
+// emojiBox 表情图片相对基线的位置 - 与文字的上下高度比例一致
+func emojiBox(dot fixed.Point26_6, size float64) image.Rectangle {
+	s := int(size + 0.5)
+	top := (dot.Y - fixed.Int26_6(size*0.88*64)).Round()
+	return image.Rect(dot.X.Round(), top, dot.X.Round()+s, top+s)
+}
+
+// drawEmoji 绘制表情图片，同时将形状绘制到蒙版
+func drawEmoji(fill *image.RGBA, mask *image.Alpha, clip image.Rectangle, dot fixed.Point26_6, g glyph) {
+	r := emojiBox(dot, g.style.size)
+	img, err := g.style.emoji.image(g.emoji, r.Dx())
+	if err != nil {
+		logger.Log.Warnw("读取表情图片错误", "err", err, "path", g.emoji)
+		return
+	}
+	cr := r.Intersect(clip)
+	if cr.Empty() == true {
+		return
+	}
+	sp := img.Bounds().Min.Add(cr.Min.Sub(r.Min))
+	draw.Draw(fill, cr, img, sp, draw.Over)
+	draw.DrawMask(mask, cr, image.Opaque, image.ZP, img, sp, draw.Over)
+}
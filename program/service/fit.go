@@ -0,0 +1,107 @@
+package service
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"github.com/nfnt/resize"
+)
+
+// 图片适配方式 - 将图片放入指定大小的区域
+
+// checkFit 检查适配方式和焦点并设置默认值
+func checkFit(fit *string, focalPoint *Point) (err error) {
+	switch *fit {
+	case "":
+		*fit = FitStretch
+	case FitCover, FitContain, FitStretch, FitTile, FitCenter:
+	default:
+		return fmt.Errorf("Unsupported image fit -- %s", *fit)
+	}
+	if focalPoint != nil && (focalPoint.X < 0 || focalPoint.X > 1 || focalPoint.Y < 0 || focalPoint.Y > 1) {
+		return fmt.Errorf("The focal point must be between 0 and 1")
+	}
+	return
+}
+
+// fitImage 按适配方式将图片放入width*height的区域，返回区域大小的图片
+// focalPoint为cover裁剪时保留的焦点，相对图片宽高的比例，为空时居中；fill为空白部分的颜色，为空时透明
+func fitImage(src image.Image, width, height int, fit string, focalPoint *Point, fill color.Color) image.Image {
+	sb := src.Bounds()
+	if width <= 0 || height <= 0 || sb.Empty() == true {
+		return src
+	}
+	if fit == FitStretch || fit == "" {
+		return resize.Resize(uint(width), uint(height), src, resize.Lanczos3)
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	if fill != nil {
+		draw.Draw(dst, dst.Bounds(), image.NewUniform(fill), image.ZP, draw.Src)
+	}
+	switch fit {
+	case FitTile:
+		// 从左上角开始按原始大小平铺
+		for y := 0; y < height; y += sb.Dy() {
+			for x := 0; x < width; x += sb.Dx() {
+				draw.Draw(dst, image.Rect(x, y, x+sb.Dx(), y+sb.Dy()), src, sb.Min, draw.Over)
+			}
+		}
+		return dst
+	case FitCenter:
+		drawCentered(dst, src)
+		return dst
+	}
+	scaleX, scaleY := float64(width)/float64(sb.Dx()), float64(height)/float64(sb.Dy())
+	scale := math.Min(scaleX, scaleY)
+	if fit == FitCover {
+		scale = math.Max(scaleX, scaleY)
+	}
+	w, h := int(math.Round(float64(sb.Dx())*scale)), int(math.Round(float64(sb.Dy())*scale))
+	if fit == FitCover {
+		w, h = maxInt(w, width), maxInt(h, height)
+	}
+	scaled := resize.Resize(uint(w), uint(h), src, resize.Lanczos3)
+	if fit == FitContain {
+		drawCentered(dst, scaled)
+		return dst
+	}
+	// 裁剪时让焦点尽量位于区域中心
+	fx, fy := 0.5, 0.5
+	if focalPoint != nil {
+		fx, fy = focalPoint.X, focalPoint.Y
+	}
+	offset := image.Pt(
+		clampInt(int(fx*float64(w))-width/2, 0, w-width),
+		clampInt(int(fy*float64(h))-height/2, 0, h-height),
+	)
+	draw.Draw(dst, dst.Bounds(), scaled, scaled.Bounds().Min.Add(offset), draw.Over)
+	return dst
+}
+
+// drawCentered 将图片按原始大小居中绘制，超出部分裁剪
+func drawCentered(dst *image.RGBA, src image.Image) {
+	sb := src.Bounds()
+	db := dst.Bounds()
+	min := image.Pt((db.Dx()-sb.Dx())/2, (db.Dy()-sb.Dy())/2).Add(db.Min)
+	draw.Draw(dst, image.Rectangle{Min: min, Max: min.Add(sb.Size())}, src, sb.Min, draw.Over)
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func clampInt(v, min, max int) int {
+	if v > max {
+		v = max
+	}
+	if v < min {
+		v = min
+	}
+	return v
+}
@@ -0,0 +1,208 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang/freetype"
+	"github.com/golang/freetype/truetype"
+	"github.com/shiguanghuxian/poster/program/config"
+	"github.com/shiguanghuxian/poster/program/logger"
+)
+
+// 字体注册表 - 启动时扫描资源目录下的字体，只能使用注册表中的字体，避免通过字体名访问任意文件
+
+// FontInfo 可用字体信息
+type FontInfo struct {
+	Name   string `json:"name"`   // 字体名 - 字体文件相对fonts目录的路径，可作为font_name使用
+	Family string `json:"family"` // 字体家族
+	Style  string `json:"style"`  // 字体样式 Regular | Bold 等
+}
+
+// fontEntry 注册表中的字体
+type fontEntry struct {
+	info FontInfo
+	font *truetype.Font
+}
+
+// fontRegistry 字体注册表
+type fontRegistry struct {
+	dir    string
+	byName map[string]*fontEntry // 字体文件名
+	byKey  map[string]*fontEntry // 小写的 family/style 和 family
+}
+
+var (
+	fontsMu sync.RWMutex
+	fonts   *fontRegistry
+)
+
+// resourcesDir 资源目录 - 未加载配置文件时为./resources
+func resourcesDir() string {
+	if config.CFG == nil || config.CFG.ResourcesDir == "" {
+		return "./resources"
+	}
+	return config.CFG.ResourcesDir
+}
+
+// fontConfig 字体配置 - 未加载配置文件时使用空配置
+func fontConfig() *config.FontConfig {
+	if config.CFG == nil || config.CFG.Font == nil {
+		return new(config.FontConfig)
+	}
+	return config.CFG.Font
+}
+
+// LoadFonts 扫描资源目录下fonts目录中的字体，替换当前字体注册表
+func LoadFonts(dir string) (err error) {
+	fontDir := filepath.Join(dir, "fonts")
+	reg := &fontRegistry{
+		dir:    fontDir,
+		byName: make(map[string]*fontEntry),
+		byKey:  make(map[string]*fontEntry),
+	}
+	// fonts目录可能是软链接
+	root, err := filepath.EvalSymlinks(fontDir)
+	if err != nil {
+		return
+	}
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".ttf", ".ttc", ".otf":
+		default:
+			return nil
+		}
+		if info.IsDir() == true {
+			return nil
+		}
+		fontBytes, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		f, err := freetype.ParseFont(fontBytes)
+		if err != nil {
+			logger.Log.Warnw("解析字体文件错误", "err", err, "path", path)
+			return nil
+		}
+		name, _ := filepath.Rel(root, path)
+		reg.add(filepath.ToSlash(name), f)
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	if len(reg.byName) == 0 {
+		logger.Log.Warnw("字体目录中没有可用字体，文本将无法绘制", "dir", fontDir)
+	} else {
+		logger.Log.Infow("加载字体", "dir", fontDir, "count", len(reg.byName))
+	}
+
+	fontsMu.Lock()
+	fonts = reg
+	fontsMu.Unlock()
+	return
+}
+
+// add 添加字体 - 同一family/style有多个文件时使用先扫描到的
+func (reg *fontRegistry) add(name string, f *truetype.Font) {
+	entry := &fontEntry{
+		info: FontInfo{
+			Name:   name,
+			Family: f.Name(truetype.NameIDFontFamily),
+			Style:  f.Name(truetype.NameIDFontSubfamily),
+		},
+		font: f,
+	}
+	reg.byName[name] = entry
+	if entry.info.Family == "" {
+		return
+	}
+	family := strings.ToLower(entry.info.Family)
+	style := strings.ToLower(entry.info.Style)
+	key := family + "/" + style
+	if _, ok := reg.byKey[key]; ok == false {
+		reg.byKey[key] = entry
+	}
+	// 只写family时优先使用Regular样式
+	if old, ok := reg.byKey[family]; ok == false || (strings.ToLower(old.info.Style) != "regular" && style == "regular") {
+		reg.byKey[family] = entry
+	}
+}
+
+// lookup 按字体文件名、family/style、family查找字体
+func (reg *fontRegistry) lookup(name string) *fontEntry {
+	if entry, ok := reg.byName[name]; ok == true {
+		return entry
+	}
+	return reg.byKey[strings.ToLower(name)]
+}
+
+// boldName 字体对应的粗体字体名，没有时返回空
+func (reg *fontRegistry) boldName(name string) string {
+	entry := reg.lookup(name)
+	if entry == nil || entry.info.Family == "" {
+		return ""
+	}
+	bold, ok := reg.byKey[strings.ToLower(entry.info.Family)+"/bold"]
+	if ok == false || bold == entry {
+		return ""
+	}
+	return bold.info.Name
+}
+
+// registry 当前字体注册表，未加载时扫描默认资源目录
+func registry() *fontRegistry {
+	fontsMu.RLock()
+	reg := fonts
+	fontsMu.RUnlock()
+	if reg != nil {
+		return reg
+	}
+	if err := LoadFonts(resourcesDir()); err != nil {
+		logger.Log.Errorw("加载字体错误", "err", err, "dir", resourcesDir())
+		// 避免每次绘制都重新扫描
+		fontsMu.Lock()
+		if fonts == nil {
+			fonts = &fontRegistry{byName: make(map[string]*fontEntry), byKey: make(map[string]*fontEntry)}
+		}
+		fontsMu.Unlock()
+	}
+	fontsMu.RLock()
+	defer fontsMu.RUnlock()
+	return fonts
+}
+
+// ListFonts 可用字体列表，按字体名排序
+func ListFonts() []*FontInfo {
+	reg := registry()
+	list := make([]*FontInfo, 0, len(reg.byName))
+	for _, entry := range reg.byName {
+		info := entry.info
+		list = append(list, &info)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// 获取字体 - 字体名可以是字体文件名、family/style或family
+func (s *Service) getFont(name string) (font *truetype.Font, err error) {
+	if name == "" {
+		return nil, errors.New("The font name cannot be empty")
+	}
+	entry := registry().lookup(name)
+	if entry == nil {
+		return nil, fmt.Errorf("Font not found -- %s", name)
+	}
+	return entry.font, nil
+}
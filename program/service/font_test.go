@@ -0,0 +1,96 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+)
+
+func TestLoadFonts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resources")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err = os.MkdirAll(filepath.Join(dir, "fonts", "go"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(filepath.Join(dir, "fonts", "go", "Go-Regular.ttf"), goregular.TTF, 0644)
+	ioutil.WriteFile(filepath.Join(dir, "fonts", "Go-Bold.ttf"), gobold.TTF, 0644)
+	ioutil.WriteFile(filepath.Join(dir, "secret.ttf"), goregular.TTF, 0644)
+
+	old := fonts
+	defer func() { fonts = old }()
+	if err = LoadFonts(dir); err != nil {
+		t.Fatal(err)
+	}
+	list := ListFonts()
+	if len(list) != 2 || list[0].Name != "Go-Bold.ttf" || list[1].Name != "go/Go-Regular.ttf" {
+		t.Fatalf("fonts got %+v %+v", list[0], list[1])
+	}
+	s := new(Service)
+	for _, name := range []string{"go/Go-Regular.ttf", "Go", "go/regular", "Go/Bold"} {
+		if _, err = s.getFont(name); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+	// 不能访问fonts目录以外的文件
+	for _, name := range []string{"../secret.ttf", "/etc/passwd", "Go-Italic.ttf"} {
+		if _, err = s.getFont(name); err == nil {
+			t.Fatalf("%s should not be found", name)
+		}
+	}
+	if bold := fonts.boldName("Go"); bold != "Go-Bold.ttf" {
+		t.Fatalf("bold font got %q", bold)
+	}
+}
+
+// loadTestFonts 使用Go字体作为默认字体，测试结束后恢复字体注册表
+func loadTestFonts(t testing.TB) {
+	dir, err := ioutil.TempDir("", "resources")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.MkdirAll(filepath.Join(dir, "fonts"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(filepath.Join(dir, "fonts", "default.ttc"), goregular.TTF, 0644)
+	old := fonts
+	t.Cleanup(func() {
+		fonts = old
+		os.RemoveAll(dir)
+	})
+	if err = LoadFonts(dir); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 字体目录为空时可以加载，使用字体时返回错误；目录不存在时返回错误由调用方决定是否继续
+func TestLoadFontsEmpty(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resources")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	old := fonts
+	defer func() { fonts = old }()
+	if err = LoadFonts(dir); err == nil {
+		t.Fatal("expected missing fonts dir error")
+	}
+	if err = os.Mkdir(filepath.Join(dir, "fonts"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = LoadFonts(dir); err != nil {
+		t.Fatal(err)
+	}
+	if list := ListFonts(); len(list) != 0 {
+		t.Fatalf("fonts got %d", len(list))
+	}
+	if _, err = new(Service).getFont("default.ttc"); err == nil {
+		t.Fatal("expected font not found error")
+	}
+}
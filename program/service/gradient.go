@@ -0,0 +1,107 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/fogleman/gg"
+	"github.com/shiguanghuxian/poster/program/common"
+)
+
+// 渐变填充 - 坐标为填充区域的比例，与区域实际大小无关
+
+// checkGradient 检查渐变参数并设置默认值
+func checkGradient(g *Gradient) (err error) {
+	switch g.Type {
+	case "":
+		g.Type = GradientLinear
+	case GradientLinear:
+	case GradientRadial:
+		if g.Center == nil {
+			g.Center = &Point{X: 0.5, Y: 0.5}
+		}
+		if g.Radius == 0 {
+			g.Radius = 0.5
+		}
+		if g.Radius < 0 {
+			return errors.New("The gradient radius cannot be negative")
+		}
+	default:
+		return fmt.Errorf("Unsupported gradient type -- %s", g.Type)
+	}
+	if len(g.Stops) < 2 {
+		return errors.New("The gradient requires at least 2 color stops")
+	}
+	// 都未设置位置时均匀分布
+	even := true
+	for _, stop := range g.Stops {
+		if stop == nil {
+			return errors.New("The gradient color stop cannot be empty")
+		}
+		if stop.Offset < 0 || stop.Offset > 1 {
+			return errors.New("The gradient color stop offset must be between 0 and 1")
+		}
+		if _, err = common.HexToColor(stop.Color); err != nil {
+			return fmt.Errorf("Gradient color stop %v", err)
+		}
+		even = even && stop.Offset == 0
+	}
+	if even == true {
+		for i, stop := range g.Stops {
+			stop.Offset = float64(i) / float64(len(g.Stops)-1)
+		}
+	}
+	return
+}
+
+// pattern 区域r内的渐变
+// 线性渐变经过区域中心，长度恰好覆盖区域四角；径向渐变半径为区域长边乘以Radius
+func (g *Gradient) pattern(r image.Rectangle) gg.Pattern {
+	x, y := float64(r.Min.X), float64(r.Min.Y)
+	w, h := float64(r.Dx()), float64(r.Dy())
+	var grad gg.Gradient
+	if g.Type == GradientRadial {
+		cx, cy := x+g.Center.X*w, y+g.Center.Y*h
+		grad = gg.NewRadialGradient(cx, cy, 0, cx, cy, g.Radius*math.Max(w, h))
+	} else {
+		rad := gg.Radians(g.Angle)
+		dx, dy := math.Cos(rad), math.Sin(rad)
+		half := (math.Abs(w*dx) + math.Abs(h*dy)) / 2
+		cx, cy := x+w/2, y+h/2
+		grad = gg.NewLinearGradient(cx-dx*half, cy-dy*half, cx+dx*half, cy+dy*half)
+	}
+	for _, stop := range g.Stops {
+		c, _ := common.HexToColor(stop.Color)
+		grad.AddColorStop(stop.Offset, c)
+	}
+	return grad
+}
+
+// image 区域r内的渐变图片，用于图片合成
+func (g *Gradient) image(r image.Rectangle) image.Image {
+	return &patternImage{pattern: g.pattern(r), rect: r}
+}
+
+// patternImage 将gg.Pattern作为图片使用
+type patternImage struct {
+	pattern gg.Pattern
+	rect    image.Rectangle
+}
+
+// ColorModel 颜色模型
+func (p *patternImage) ColorModel() color.Model {
+	return color.RGBAModel
+}
+
+// Bounds 图片区域
+func (p *patternImage) Bounds() image.Rectangle {
+	return p.rect
+}
+
+// At 指定位置的颜色
+func (p *patternImage) At(x, y int) color.Color {
+	return p.pattern.ColorAt(x, y)
+}
@@ -0,0 +1,103 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// newLayers 合并分类字段和图层列表，按层级排序后返回绘制顺序
+// 分类字段兼容旧版本，依次转换为子图片、二维码、小程序码、文本图层，排在图层列表之前
+func newLayers(param *PosterParam) (layers []*Layer, err error) {
+	for k, v := range param.SubImages {
+		layers = append(layers, &Layer{Type: LayerTypeImage, Image: v, path: fmt.Sprintf("sub_images[%d]", k)})
+	}
+	for k, v := range param.SubQrCode {
+		layers = append(layers, &Layer{Type: LayerTypeQrCode, QrCode: v, path: fmt.Sprintf("sub_qr_code[%d]", k)})
+	}
+	for k, v := range param.SubWxQrCode {
+		layers = append(layers, &Layer{Type: LayerTypeWxQrCode, WxQrCode: v, path: fmt.Sprintf("sub_wx_qr_code[%d]", k)})
+	}
+	for k, v := range param.Texts {
+		layers = append(layers, &Layer{Type: LayerTypeText, Text: v, path: fmt.Sprintf("texts[%d]", k)})
+	}
+	for k, l := range param.Layers {
+		if l == nil {
+			return nil, fmt.Errorf("layers[%d]: The layer cannot be nil", k)
+		}
+		l.path = fmt.Sprintf("layers[%d]", k)
+		layers = append(layers, l)
+	}
+
+	for _, l := range layers {
+		err = l.check()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", l.path, err)
+		}
+	}
+	// 层级相同时保持原有顺序
+	sort.SliceStable(layers, func(i, j int) bool {
+		return layers[i].ZIndex < layers[j].ZIndex
+	})
+	return
+}
+
+// check 检查图层类型和内容，并设置默认值
+func (l *Layer) check() (err error) {
+	types := make([]string, 0, 1)
+	if l.Text != nil {
+		types = append(types, LayerTypeText)
+	}
+	if l.Image != nil {
+		types = append(types, LayerTypeImage)
+	}
+	if l.QrCode != nil {
+		types = append(types, LayerTypeQrCode)
+	}
+	if l.WxQrCode != nil {
+		types = append(types, LayerTypeWxQrCode)
+	}
+	if l.Shape != nil {
+		types = append(types, LayerTypeShape)
+	}
+	if len(types) != 1 {
+		return errors.New("Each layer must contain exactly one of text, image, qr_code, wx_qr_code or shape")
+	}
+	if l.Type == "" {
+		l.Type = types[0]
+	}
+	if l.Type != types[0] {
+		return fmt.Errorf("Layer type %s does not match its content %s", l.Type, types[0])
+	}
+
+	switch l.Type {
+	case LayerTypeText:
+		err = checkText(l.Text)
+	case LayerTypeImage:
+		err = checkImage(l.Image)
+	case LayerTypeQrCode:
+		err = checkQrCode(l.QrCode)
+	case LayerTypeWxQrCode:
+		err = checkWxQrCode(l.WxQrCode)
+	case LayerTypeShape:
+		err = checkShape(l.Shape)
+	}
+	return
+}
+
+// subObject 图层内容的位置、大小等公共参数
+func (l *Layer) subObject() *SubObject {
+	switch l.Type {
+	case LayerTypeText:
+		return &l.Text.SubObject
+	case LayerTypeImage:
+		return &l.Image.SubObject
+	case LayerTypeQrCode:
+		return &l.QrCode.SubObject
+	case LayerTypeWxQrCode:
+		return &l.WxQrCode.SubObject
+	case LayerTypeShape:
+		return &l.Shape.SubObject
+	}
+	return &SubObject{}
+}
@@ -0,0 +1,49 @@
+package service
+
+import (
+	"fmt"
+	"image"
+)
+
+// 锚点在元素宽高中的比例位置
+var anchorFactors = map[string][2]float64{
+	AnchorTopLeft:     {0, 0},
+	AnchorTop:         {0.5, 0},
+	AnchorTopRight:    {1, 0},
+	AnchorLeft:        {0, 0.5},
+	AnchorCenter:      {0.5, 0.5},
+	AnchorRight:       {1, 0.5},
+	AnchorBottomLeft:  {0, 1},
+	AnchorBottom:      {0.5, 1},
+	AnchorBottomRight: {1, 1},
+}
+
+// checkAnchor 检查锚点是否合法
+func checkAnchor(anchor string) error {
+	if anchor == "" {
+		return nil
+	}
+	if _, ok := anchorFactors[anchor]; ok == false {
+		return fmt.Errorf("Unsupported anchor -- %s", anchor)
+	}
+	return nil
+}
+
+// origin 计算宽高为width*height的元素左上角在画布中的位置
+// legacy为true且未设置锚点时，兼容旧版本偏移半个元素宽高
+func (so *SubObject) origin(width, height int, legacy bool) image.Point {
+	if legacy == true && so.Anchor == "" {
+		return image.Point{so.Left + width/2, so.Top + height/2}
+	}
+	factor := anchorFactors[so.Anchor]
+	return image.Point{
+		so.Left - int(factor[0]*float64(width)),
+		so.Top - int(factor[1]*float64(height)),
+	}
+}
+
+// bounds 元素在画布中占据的区域
+func (so *SubObject) bounds(width, height int, legacy bool) image.Rectangle {
+	pt := so.origin(width, height, legacy)
+	return image.Rect(pt.X, pt.Y, pt.X+width, pt.Y+height)
+}
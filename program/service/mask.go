@@ -0,0 +1,66 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+
+	"github.com/nfnt/resize"
+)
+
+// 蒙版 - 使用蒙版图片的透明度或亮度裁剪图层
+
+// checkMask 检查蒙版参数并设置默认值
+func checkMask(m *Mask) (err error) {
+	if len(m.Image) == 0 && m.ImageURL == "" {
+		return errors.New("Mask image url and image base64 are both empty")
+	}
+	switch m.Mode {
+	case "":
+		m.Mode = MaskModeAlpha
+	case MaskModeAlpha, MaskModeLuminance:
+	default:
+		return fmt.Errorf("Unsupported mask mode -- %s", m.Mode)
+	}
+	return
+}
+
+// size 图层内容的宽高 - 二维码和小程序码只有宽度
+func (l *Layer) size() (width, height int) {
+	so := l.subObject()
+	switch l.Type {
+	case LayerTypeQrCode, LayerTypeWxQrCode:
+		return so.Width, so.Width
+	}
+	return so.Width, so.Height
+}
+
+// bounds 图层内容在画布中的区域
+func (l *Layer) bounds(legacy bool) image.Rectangle {
+	width, height := l.size()
+	// 文本和图形始终按锚点定位
+	if l.Type == LayerTypeText || l.Type == LayerTypeShape {
+		legacy = false
+	}
+	return l.subObject().bounds(width, height, legacy)
+}
+
+// newAlphaMask 将蒙版图片缩放到r大小并转为透明度蒙版，opacity为整体不透明度(0-1)
+func newAlphaMask(src image.Image, r image.Rectangle, mode string, opacity float64) *image.Alpha {
+	scaled := resize.Resize(uint(r.Dx()), uint(r.Dy()), src, resize.Bilinear)
+	sb := scaled.Bounds()
+	mask := image.NewAlpha(r)
+	for y := 0; y < r.Dy(); y++ {
+		for x := 0; x < r.Dx(); x++ {
+			c := color.NRGBA64Model.Convert(scaled.At(sb.Min.X+x, sb.Min.Y+y)).(color.NRGBA64)
+			a := float64(c.A) / 0xffff
+			if mode == MaskModeLuminance {
+				a *= (0.299*float64(c.R) + 0.587*float64(c.G) + 0.114*float64(c.B)) / 0xffff
+			}
+			a *= opacity
+			mask.SetAlpha(r.Min.X+x, r.Min.Y+y, color.Alpha{A: uint8(a*0xff + 0.5)})
+		}
+	}
+	return mask
+}
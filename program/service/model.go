@@ -7,46 +7,186 @@ const (
 	DefaultWidth       = 720  // 画布宽度
 	DefaultHeight      = 1280 // 画布高度
 	DefaultBorderWidth = 6    // 边框线条宽度
+	DefaultMinFontSize = 12   // 文字自动缩小时的最小字号
+	MaxScale           = 4    // 最大绘制倍数
+	MaxTextStrokeWidth = 32   // 文字描边最大宽度 - 按倍数放大后也不超过
+	MaxTextShadowBlur  = 64   // 文字阴影最大模糊半径 - 按倍数放大后也不超过
+)
+
+// 布局模式
+const (
+	LayoutModeLegacy = 1 // 兼容旧版本 - 子图片、二维码、小程序码左上角位于Left+Width/2, Top+Height/2
+	LayoutModeExact  = 2 // 精确定位 - 按锚点将元素放置在Left、Top
+)
+
+// 锚点 - Left、Top所对应的元素位置
+const (
+	AnchorTopLeft     = "top-left"
+	AnchorTop         = "top"
+	AnchorTopRight    = "top-right"
+	AnchorLeft        = "left"
+	AnchorCenter      = "center"
+	AnchorRight       = "right"
+	AnchorBottomLeft  = "bottom-left"
+	AnchorBottom      = "bottom"
+	AnchorBottomRight = "bottom-right"
 )
 
 // PosterParam 生成海报参数
 type PosterParam struct {
 	Width       int         `json:"width,omitempty"`          // 画布宽度
 	Height      int         `json:"height,omitempty"`         // 画布高度
-	Background  *Background `json:"background,omitempty"`     // 背景图片
+	Background  *Background `json:"background,omitempty"`     // 背景 - 不传时为白色，输出png和webp时为透明
 	Texts       []*Text     `json:"texts,omitempty"`          // 文本列表
 	SubImages   []*Image    `json:"sub_images,omitempty"`     // 需要插入的子图片列表
 	SubQrCode   []*QrCode   `json:"sub_qr_code,omitempty"`    // 需要每次都动态生成的二维码信息
 	SubWxQrCode []*WxQrCode `json:"sub_wx_qr_code,omitempty"` // 微信小程序码
+	Layers      []*Layer    `json:"layers,omitempty"`         // 图层列表 - 按层级绘制，可与以上分类字段同时使用
+	LayoutMode  int         `json:"layout_mode,omitempty"`    // 布局模式 1:兼容旧版本 2:精确定位 - 不传时只使用layers的请求为2，否则为1
+	Output      *Output     `json:"output,omitempty"`         // 输出图片参数 - 不传时为质量75的jpg
+	Scale       float64     `json:"scale,omitempty"`          // 绘制倍数 - 坐标、尺寸、字号按逻辑像素传，输出图片宽高为Width*Scale，默认1
+	Scales      []float64   `json:"scales,omitempty"`         // 一次生成多个倍数的图片，如[1, 2, 3] - 设置后忽略Scale
+}
+
+// ScaledImage 按倍数生成的图片
+type ScaledImage struct {
+	Scale  float64 `json:"scale"`  // 绘制倍数
+	Width  int     `json:"width"`  // 图片宽度
+	Height int     `json:"height"` // 图片高度
+	Image  []byte  `json:"image"`  // 图片内容
+}
+
+// Output 输出图片参数
+type Output struct {
+	Format           string `json:"format,omitempty"`            // 图片格式 jpeg | png | webp - 默认jpeg，webp为无损压缩，体积可能大于png
+	Quality          int    `json:"quality,omitempty"`           // jpg质量 1-100 - 默认75，只对jpeg有效，webp不支持
+	Progressive      bool   `json:"progressive,omitempty"`       // 是否输出渐进式jpg
+	CompressionLevel int    `json:"compression_level,omitempty"` // png压缩级别 -1:不压缩 1-3:最快 4-6:默认 7-9:最小 - 默认0同4-6
 }
 
-// Background 背景 - Image和ImageUrl至少传一个
+// 图层类型
+const (
+	LayerTypeText     = "text"       // 文本
+	LayerTypeImage    = "image"      // 子图片
+	LayerTypeQrCode   = "qr_code"    // 二维码
+	LayerTypeWxQrCode = "wx_qr_code" // 小程序码
+	LayerTypeShape    = "shape"      // 矢量图形
+)
+
+// Layer 海报图层 - 按z_index从小到大绘制，z_index相同时按数组顺序绘制
+// 每个图层只能设置Text、Image、QrCode、WxQrCode、Shape其中一个
+type Layer struct {
+	Type     string    `json:"type,omitempty"`       // 图层类型 text | image | qr_code | wx_qr_code | shape - 为空时根据内容判断
+	ZIndex   int       `json:"z_index,omitempty"`    // 层级 - 值越大越靠上
+	Text     *Text     `json:"text,omitempty"`       // 文本
+	Image    *Image    `json:"image,omitempty"`      // 子图片
+	QrCode   *QrCode   `json:"qr_code,omitempty"`    // 二维码
+	WxQrCode *WxQrCode `json:"wx_qr_code,omitempty"` // 小程序码
+	Shape    *Shape    `json:"shape,omitempty"`      // 矢量图形
+
+	path string // 图层在请求参数中的位置，用于日志和错误信息
+}
+
+// Background 背景 - Image、ImageUrl、Color、Gradient至少传一个，不传背景时为白色
+// 按背景色、渐变、图片的顺序绘制，图片透明部分显示下面的颜色
 type Background struct {
-	Image     []byte `json:"image,omitempty"`      // 图片base64值
-	ImageURL  string `json:"image_url,omitempty"`  // 背景图片地址
-	ImageType string `json:"image_type,omitempty"` // 图片格式类型 jpg | png
+	Image     []byte    `json:"image,omitempty"`      // 图片base64值
+	ImageURL  string    `json:"image_url,omitempty"`  // 背景图片地址
+	ImageType string    `json:"image_type,omitempty"` // 图片格式类型 jpg | png | gif | bmp | tiff | webp - 可为空，根据图片内容识别，无法识别时使用
+	Color     string    `json:"color,omitempty"`      // 背景色 - 支持#RRGGBBAA
+	Gradient  *Gradient `json:"gradient,omitempty"`   // 渐变
+
+	Fit        string `json:"fit,omitempty"`         // 图片适配方式 cover | contain | stretch | tile | center - 默认stretch
+	FocalPoint *Point `json:"focal_point,omitempty"` // cover裁剪时保留的焦点 - 相对图片宽高的比例，默认{"x": 0.5, "y": 0.5}
+}
+
+// 图片适配方式
+const (
+	FitCover   = "cover"   // 等比缩放覆盖整个区域，按焦点裁剪超出部分
+	FitContain = "contain" // 等比缩放完整显示，空白部分显示背景色
+	FitStretch = "stretch" // 拉伸至区域大小
+	FitTile    = "tile"    // 按原始大小从左上角平铺
+	FitCenter  = "center"  // 按原始大小居中，不缩放
+)
+
+// 渐变类型
+const (
+	GradientLinear = "linear" // 线性渐变
+	GradientRadial = "radial" // 径向渐变
+)
+
+// Gradient 渐变
+type Gradient struct {
+	Type   string       `json:"type,omitempty"`   // 渐变类型 linear | radial - 默认linear
+	Angle  float64      `json:"angle,omitempty"`  // 线性渐变方向 - 角度，0为从左到右，90为从上到下
+	Center *Point       `json:"center,omitempty"` // 径向渐变中心 - 相对填充区域宽高的比例，默认{"x": 0.5, "y": 0.5}
+	Radius float64      `json:"radius,omitempty"` // 径向渐变半径 - 相对填充区域长边的比例，默认0.5
+	Stops  []*ColorStop `json:"stops,omitempty"`  // 颜色节点 - 至少2个
+}
+
+// ColorStop 渐变颜色节点
+type ColorStop struct {
+	Offset float64 `json:"offset,omitempty"` // 位置 0-1 - 都不传时均匀分布
+	Color  string  `json:"color,omitempty"`  // 颜色 - 支持#RRGGBBAA
 }
 
 // SubObject 子对象位置和大小
 type SubObject struct {
-	Top    int `json:"top,omitempty"`    // 距离顶部距离
-	Left   int `json:"left,omitempty"`   // 距离左侧距离
-	Width  int `json:"width,omitempty"`  // 文本区域宽度 - 当二维码和小程序码时只有宽度生效
-	Height int `json:"height,omitempty"` // 文本区域高度
+	Top     int      `json:"top,omitempty"`     // 距离顶部距离
+	Left    int      `json:"left,omitempty"`    // 距离左侧距离
+	Width   int      `json:"width,omitempty"`   // 文本区域宽度 - 当二维码和小程序码时只有宽度生效
+	Height  int      `json:"height,omitempty"`  // 文本区域高度
+	Opacity *float64 `json:"opacity,omitempty"` // 不透明度 0-1 - 不传表示不透明，0表示完全透明
+	Anchor  string   `json:"anchor,omitempty"`  // 锚点 top-left | top | top-right | left | center | right | bottom-left | bottom | bottom-right - 默认top-left
+	Mask    *Mask    `json:"mask,omitempty"`    // 蒙版 - 缩放到元素区域，区域外的部分不显示
 }
 
+// Mask 蒙版图片 - Image和ImageUrl至少传一个
+type Mask struct {
+	Image    []byte `json:"image,omitempty"`     // 图片base64值
+	ImageURL string `json:"image_url,omitempty"` // 图片地址
+	Mode     string `json:"mode,omitempty"`      // alpha:使用透明度 luminance:使用亮度，白色显示、黑色隐藏 - 默认alpha
+}
+
+// 蒙版模式
+const (
+	MaskModeAlpha     = "alpha"     // 透明度
+	MaskModeLuminance = "luminance" // 亮度
+)
+
 // Text 海报文字
 type Text struct {
 	SubObject
-	LineCount  int     `json:"line_count,omitempty"`  // 每行字符数 - 长度 汉字=2 字母=1
+	LineCount  int     `json:"line_count,omitempty"`  // 最大行数 - 0或不传不限制，按Width自动换行
 	Content    string  `json:"content,omitempty"`     // 文字内容
 	FontName   string  `json:"font_name,omitempty"`   // 字体名 - 需要先将字体问题放到资源目录
 	FontSize   float64 `json:"font_size,omitempty"`   // 字体大小
 	LineHeight float64 `json:"line_height,omitempty"` // 行间距
 	FontColor  string  `json:"font_color,omitempty"`  // 字体颜色
+
+	FallbackFonts []string `json:"fallback_fonts,omitempty"` // 备用字体 - 字体中没有的字符依次从这些字体中查找，之后查找配置文件中的全局备用字体
+
+	Spans         []*TextSpan `json:"spans,omitempty"`          // 文字片段 - 设置后忽略Content，各片段依次排列、一起换行
+	Bold          bool        `json:"bold,omitempty"`           // 加粗
+	Underline     bool        `json:"underline,omitempty"`      // 下划线
+	Strikethrough bool        `json:"strikethrough,omitempty"`  // 删除线
+	LetterSpacing float64     `json:"letter_spacing,omitempty"` // 字间距 - 可为负数
+
+	Align         string `json:"align,omitempty"`          // 水平对齐 left | center | right | justify - 默认left
+	VerticalAlign string `json:"vertical_align,omitempty"` // 垂直对齐 top | middle | bottom - 默认top
+
+	Overflow    string  `json:"overflow,omitempty"`      // 超出文本框时的处理方式 clip | ellipsis | shrink - 默认clip
+	MinFontSize float64 `json:"min_font_size,omitempty"` // shrink时的最小字号 - 默认12
+
+	Stroke     *TextStroke     `json:"stroke,omitempty"`     // 文字描边
+	Shadow     *TextShadow     `json:"shadow,omitempty"`     // 文字阴影
+	Background *TextBackground `json:"background,omitempty"` // 文字背景框
+	Fill       *TextFill       `json:"fill,omitempty"`       // 文字填充 - 设置后使用图片或渐变填充文字，忽略字体颜色
 }
 
-// GetTest 获取换行后的文本 - 会根据每行字符数换行
+// GetTest 获取换行后的文本 - 按每行字符数换行，汉字=2 字母=1，LineCount为每行字符数
+//
+// Deprecated: 绘制时已按字形宽度在Width内换行，LineCount改为最大行数，不再使用此方法，保留只为兼容旧代码
 func (txt *Text) GetTest() (texts []string) {
 	line := 0
 	count := 0
@@ -70,15 +210,124 @@ func (txt *Text) GetTest() (texts []string) {
 	return
 }
 
+// TextFill 文字填充 - Image、ImageUrl、Gradient至少传一个，填充区域为文本框，未设置宽高时为文字所在区域
+type TextFill struct {
+	Image    []byte    `json:"image,omitempty"`     // 图片base64值 - 等比缩放覆盖填充区域
+	ImageURL string    `json:"image_url,omitempty"` // 图片地址
+	Gradient *Gradient `json:"gradient,omitempty"`  // 渐变 - 优先于图片
+}
+
+// TextSpan 文字片段 - 未设置的属性继承所在文本
+type TextSpan struct {
+	Content       string  `json:"content,omitempty"`        // 文字内容
+	FontName      string  `json:"font_name,omitempty"`      // 字体名
+	FontSize      float64 `json:"font_size,omitempty"`      // 字体大小
+	FontColor     string  `json:"font_color,omitempty"`     // 字体颜色
+	Bold          bool    `json:"bold,omitempty"`           // 加粗
+	Underline     bool    `json:"underline,omitempty"`      // 下划线
+	Strikethrough bool    `json:"strikethrough,omitempty"`  // 删除线
+	LetterSpacing float64 `json:"letter_spacing,omitempty"` // 字间距
+}
+
+// TextStroke 文字描边
+type TextStroke struct {
+	Color string  `json:"color,omitempty"` // 描边颜色 - 默认白色
+	Width float64 `json:"width,omitempty"` // 描边宽度 - 默认2，最大32
+}
+
+// TextShadow 文字阴影
+type TextShadow struct {
+	OffsetX int    `json:"offset_x,omitempty"` // 水平偏移
+	OffsetY int    `json:"offset_y,omitempty"` // 垂直偏移
+	Blur    int    `json:"blur,omitempty"`     // 模糊半径 - 最大64
+	Color   string `json:"color,omitempty"`    // 阴影颜色 - 支持#RRGGBBAA，默认#00000080
+}
+
+// TextBackground 文字背景框
+type TextBackground struct {
+	Color   string  `json:"color,omitempty"`   // 背景色 - 支持#RRGGBBAA
+	Padding int     `json:"padding,omitempty"` // 内边距
+	Radius  float64 `json:"radius,omitempty"`  // 圆角半径
+	Mode    string  `json:"mode,omitempty"`    // block:整个文字块一个背景 line:每行一个背景 - 默认block
+}
+
+// 文字超出文本框时的处理方式
+const (
+	OverflowClip     = "clip"     // 超出部分裁剪
+	OverflowEllipsis = "ellipsis" // 最后一行末尾显示省略号
+	OverflowShrink   = "shrink"   // 逐步缩小字号直到放下，最小到MinFontSize
+)
+
+// 文字背景框模式
+const (
+	TextBackgroundBlock = "block" // 整个文字块一个背景
+	TextBackgroundLine  = "line"  // 每行一个背景
+)
+
+// 文本对齐方式
+const (
+	AlignLeft    = "left"    // 左对齐
+	AlignCenter  = "center"  // 居中
+	AlignRight   = "right"   // 右对齐
+	AlignJustify = "justify" // 两端对齐 - 段落最后一行左对齐
+
+	VerticalAlignTop    = "top"    // 顶部对齐
+	VerticalAlignMiddle = "middle" // 垂直居中
+	VerticalAlignBottom = "bottom" // 底部对齐
+)
+
 // Image 海报贴图 - Image和ImageUrl至少传一个
 type Image struct {
 	SubObject
 	Padding   int     `json:"padding,omitempty"`    // 内边距 - 当图片旋转时有用
 	Angle     float64 `json:"angle,omitempty"`      // 旋转角度 - 顺时针方向 - 弧度
 	Color     string  `json:"color,omitempty"`      // 背景色
-	ImageType string  `json:"image_type,omitempty"` // 图片格式类型 jpg | png
+	ImageType string  `json:"image_type,omitempty"` // 图片格式类型 jpg | png | gif | bmp | tiff | webp - 可为空，根据图片内容识别，无法识别时使用
 	Image     []byte  `json:"image,omitempty"`      // 图片base64值
 	ImageURL  string  `json:"image_url,omitempty"`  // 背景图片地址
+
+	Fit        string `json:"fit,omitempty"`         // 图片适配方式 cover | contain | stretch | tile | center - 默认stretch，contain时空白部分使用Color
+	FocalPoint *Point `json:"focal_point,omitempty"` // cover裁剪时保留的焦点 - 相对图片宽高的比例
+
+	Shape        string  `json:"shape,omitempty"`         // 形状 rect | circle | ellipse - 默认rect，circle时直径为宽高中较小的值
+	BorderRadius Radius  `json:"border_radius,omitempty"` // 圆角半径 - 一个数字，或左上、右上、右下、左下4个数字，shape为rect时有效
+	Border       *Border `json:"border,omitempty"`        // 边框 - 沿形状内侧绘制
+}
+
+// 图形类型 - 子图片形状只支持rect、circle、ellipse
+const (
+	ShapeRect        = "rect"         // 矩形
+	ShapeRoundedRect = "rounded_rect" // 圆角矩形
+	ShapeCircle      = "circle"       // 圆形 - 直径为宽高中较小的值，居中
+	ShapeEllipse     = "ellipse"      // 椭圆
+	ShapeLine        = "line"         // 直线 - 2个点
+	ShapePolyline    = "polyline"     // 折线 - 至少2个点
+	ShapePolygon     = "polygon"      // 多边形 - 至少3个点，自动闭合
+)
+
+// Shape 矢量图形 - 矩形、圆形、椭圆绘制在Width*Height区域内，线和多边形的点坐标相对于区域左上角
+type Shape struct {
+	SubObject
+	Type        string    `json:"type,omitempty"`         // 图形类型 rect | rounded_rect | circle | ellipse | line | polyline | polygon
+	Radius      Radius    `json:"radius,omitempty"`       // 圆角半径 - rounded_rect时有效，一个数字或左上、右上、右下、左下4个数字
+	Points      []*Point  `json:"points,omitempty"`       // 点坐标 - line、polyline、polygon时有效
+	FillColor   string    `json:"fill_color,omitempty"`   // 填充色 - 支持#RRGGBBAA，为空不填充
+	Gradient    *Gradient `json:"gradient,omitempty"`     // 渐变填充 - 设置后忽略FillColor
+	StrokeColor string    `json:"stroke_color,omitempty"` // 线条颜色 - 为空不绘制线条，线段图形默认黑色
+	StrokeWidth float64   `json:"stroke_width,omitempty"` // 线条宽度 - 默认1
+	Dash        []float64 `json:"dash,omitempty"`         // 虚线 - 线段和间隔长度交替，如[10, 5]
+}
+
+// Point 坐标点
+type Point struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// Border 边框
+type Border struct {
+	Color string  `json:"color,omitempty"` // 边框颜色 - 支持#RRGGBBAA，默认白色
+	Width float64 `json:"width,omitempty"` // 边框宽度 - 默认6
 }
 
 // QrCode 子二维码，根据内容生成 - 非图片
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"bytes"
+	"image"
+	"image/draw"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// exifOrientation 读取jpg图片EXIF中的方向 - 没有EXIF或读取失败时返回1(正常方向)
+func exifOrientation(body []byte) int {
+	x, err := exif.Decode(bytes.NewReader(body))
+	if err != nil {
+		return 1
+	}
+	tag, err := x.Get(exif.Orientation)
+	if err != nil {
+		return 1
+	}
+	orientation, err := tag.Int(0)
+	if err != nil || orientation < 1 || orientation > 8 {
+		return 1
+	}
+	return orientation
+}
+
+// applyOrientation 按EXIF方向将图片转为正常方向
+// 2:水平翻转 3:旋转180度 4:垂直翻转 5:沿左上-右下对角线翻转 6:顺时针旋转90度 7:沿右上-左下对角线翻转 8:逆时针旋转90度
+func applyOrientation(src image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return src
+	}
+	sb := src.Bounds()
+	w, h := sb.Dx(), sb.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	rgba := image.NewRGBA(sb)
+	draw.Draw(rgba, sb, src, sb.Min, draw.Src)
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			// 目标像素对应的原图坐标
+			var sx, sy int
+			switch orientation {
+			case 2:
+				sx, sy = w-1-x, y
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sx, sy = x, h-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			}
+			i := rgba.PixOffset(sb.Min.X+sx, sb.Min.Y+sy)
+			copy(dst.Pix[dst.PixOffset(x, y):dst.PixOffset(x, y)+4], rgba.Pix[i:i+4])
+		}
+	}
+	return dst
+}
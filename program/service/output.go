@@ -0,0 +1,87 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	"github.com/shiguanghuxian/poster/program/encoder"
+)
+
+// 输出图片格式
+const (
+	OutputFormatJPEG = "jpeg"
+	OutputFormatPNG  = "png"
+	OutputFormatWebP = "webp"
+)
+
+// checkOutput 检查输出参数并设置默认值
+func checkOutput(o *Output) (err error) {
+	switch o.Format {
+	case "", "jpg":
+		o.Format = OutputFormatJPEG
+	case OutputFormatJPEG, OutputFormatPNG, OutputFormatWebP:
+	default:
+		return fmt.Errorf("Unsupported output format -- %s", o.Format)
+	}
+	if o.Quality < 0 || o.Quality > 100 {
+		return errors.New("The output quality must be between 1 and 100")
+	}
+	if o.Format == OutputFormatWebP && o.Quality != 0 {
+		return errors.New("The webp output is lossless and does not support quality")
+	}
+	if o.Quality == 0 {
+		o.Quality = jpeg.DefaultQuality
+	}
+	if o.CompressionLevel < -1 || o.CompressionLevel > 9 {
+		return errors.New("The png compression level must be between -1 and 9")
+	}
+	return
+}
+
+// MimeType 输出图片的MIME类型
+func (o *Output) MimeType() string {
+	switch o.Format {
+	case OutputFormatPNG:
+		return "image/png"
+	case OutputFormatWebP:
+		return "image/webp"
+	}
+	return "image/jpeg"
+}
+
+// transparent 输出格式是否支持透明
+func (o *Output) transparent() bool {
+	return o.Format == OutputFormatPNG || o.Format == OutputFormatWebP
+}
+
+// pngCompression 压缩级别转为png编码器的压缩方式 - 兼容zlib的0-9习惯
+func (o *Output) pngCompression() png.CompressionLevel {
+	switch {
+	case o.CompressionLevel == -1:
+		return png.NoCompression
+	case o.CompressionLevel >= 1 && o.CompressionLevel <= 3:
+		return png.BestSpeed
+	case o.CompressionLevel >= 7:
+		return png.BestCompression
+	}
+	return png.DefaultCompression
+}
+
+// encode 按输出格式编码图片
+func (o *Output) encode(w io.Writer, img image.Image) error {
+	switch o.Format {
+	case OutputFormatPNG:
+		enc := &png.Encoder{CompressionLevel: o.pngCompression()}
+		return enc.Encode(w, img)
+	case OutputFormatWebP:
+		return encoder.EncodeWebP(w, img)
+	}
+	if o.Progressive == true {
+		return encoder.EncodeProgressiveJPEG(w, img, o.Quality)
+	}
+	return jpeg.Encode(w, img, &jpeg.Options{Quality: o.Quality})
+}
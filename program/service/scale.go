@@ -0,0 +1,166 @@
+package service
+
+import (
+	"errors"
+	"image"
+	"math"
+
+	"github.com/nfnt/resize"
+)
+
+// 按倍数绘制 - 请求中的坐标、尺寸、字号都是逻辑像素，绘制前统一乘以倍数，用于生成2x、3x图片
+
+// checkScale 检查绘制倍数并设置默认值
+func checkScale(param *PosterParam) (err error) {
+	if param.Scale == 0 {
+		param.Scale = 1
+	}
+	for _, scale := range append([]float64{param.Scale}, param.Scales...) {
+		if scale <= 0 || scale > MaxScale {
+			return errors.New("The scale must be greater than 0 and no more than 4")
+		}
+	}
+	return
+}
+
+// scaled 返回按倍数绘制的对象 - 参数已检查并设置默认值，这里只复制并缩放像素相关的字段
+func (s *Service) scaled(scale float64) *Service {
+	param := *s.Param
+	ss := &Service{Param: &param, scale: scale, sources: s.sources}
+	if scale == 1 {
+		ss.layers = s.layers
+		return ss
+	}
+	param.Width = scaleInt(param.Width, scale)
+	param.Height = scaleInt(param.Height, scale)
+	for _, l := range s.layers {
+		ss.layers = append(ss.layers, l.scaled(scale))
+	}
+	return ss
+}
+
+// scaled 复制图层并缩放内容
+func (l *Layer) scaled(scale float64) *Layer {
+	sl := *l
+	switch l.Type {
+	case LayerTypeText:
+		sl.Text = l.Text.scaled(scale)
+	case LayerTypeImage:
+		sl.Image = l.Image.scaled(scale)
+	case LayerTypeQrCode:
+		qr := *l.QrCode
+		qr.SubObject = qr.SubObject.scaled(scale)
+		sl.QrCode = &qr
+	case LayerTypeWxQrCode:
+		wx := *l.WxQrCode
+		wx.SubObject = wx.SubObject.scaled(scale)
+		sl.WxQrCode = &wx
+	case LayerTypeShape:
+		sl.Shape = l.Shape.scaled(scale)
+	}
+	return &sl
+}
+
+// scaled 缩放位置和大小
+func (so SubObject) scaled(scale float64) SubObject {
+	so.Top = scaleInt(so.Top, scale)
+	so.Left = scaleInt(so.Left, scale)
+	so.Width = scaleInt(so.Width, scale)
+	so.Height = scaleInt(so.Height, scale)
+	return so
+}
+
+// scaled 复制文本并缩放字号、字间距和效果
+func (txt *Text) scaled(scale float64) *Text {
+	st := *txt
+	st.SubObject = txt.SubObject.scaled(scale)
+	st.FontSize *= scale
+	st.MinFontSize *= scale
+	st.LetterSpacing *= scale
+	st.Spans = nil
+	for _, span := range txt.Spans {
+		ss := *span
+		ss.FontSize *= scale
+		ss.LetterSpacing *= scale
+		st.Spans = append(st.Spans, &ss)
+	}
+	// 描边和阴影的计算量随半径增加，放大后不超过最大值
+	if txt.Stroke != nil {
+		stroke := *txt.Stroke
+		stroke.Width = math.Min(stroke.Width*scale, MaxTextStrokeWidth)
+		st.Stroke = &stroke
+	}
+	if txt.Shadow != nil {
+		shadow := *txt.Shadow
+		shadow.OffsetX = scaleInt(shadow.OffsetX, scale)
+		shadow.OffsetY = scaleInt(shadow.OffsetY, scale)
+		if shadow.Blur = scaleInt(shadow.Blur, scale); shadow.Blur > MaxTextShadowBlur {
+			shadow.Blur = MaxTextShadowBlur
+		}
+		st.Shadow = &shadow
+	}
+	if txt.Background != nil {
+		bg := *txt.Background
+		bg.Padding = scaleInt(bg.Padding, scale)
+		bg.Radius *= scale
+		st.Background = &bg
+	}
+	return &st
+}
+
+// scaled 复制子图片并缩放内边距、圆角和边框
+func (subImg *Image) scaled(scale float64) *Image {
+	si := *subImg
+	si.SubObject = subImg.SubObject.scaled(scale)
+	si.Padding = scaleInt(si.Padding, scale)
+	si.BorderRadius = subImg.BorderRadius.scaled(scale)
+	if subImg.Border != nil {
+		border := *subImg.Border
+		border.Width *= scale
+		si.Border = &border
+	}
+	return &si
+}
+
+// scaled 复制图形并缩放点坐标、圆角和线条
+func (shape *Shape) scaled(scale float64) *Shape {
+	ss := *shape
+	ss.SubObject = shape.SubObject.scaled(scale)
+	ss.Radius = shape.Radius.scaled(scale)
+	ss.StrokeWidth *= scale
+	ss.Points = nil
+	for _, p := range shape.Points {
+		ss.Points = append(ss.Points, &Point{X: p.X * scale, Y: p.Y * scale})
+	}
+	ss.Dash = nil
+	for _, d := range shape.Dash {
+		ss.Dash = append(ss.Dash, d*scale)
+	}
+	return &ss
+}
+
+// scaled 缩放圆角半径
+func (r Radius) scaled(scale float64) Radius {
+	if len(r) == 0 {
+		return r
+	}
+	sr := make(Radius, len(r))
+	for i, v := range r {
+		sr[i] = v * scale
+	}
+	return sr
+}
+
+// scaleInt 缩放整数像素值并四舍五入
+func scaleInt(v int, scale float64) int {
+	return int(math.Round(float64(v) * scale))
+}
+
+// scaleFitImage 平铺和居中时图片按原始大小显示，原始大小也按倍数缩放
+func (s *Service) scaleFitImage(src image.Image, fit string) image.Image {
+	if s.scale == 0 || s.scale == 1 || (fit != FitTile && fit != FitCenter) {
+		return src
+	}
+	b := src.Bounds()
+	return resize.Resize(uint(scaleInt(b.Dx(), s.scale)), uint(scaleInt(b.Dy(), s.scale)), src, resize.Lanczos3)
+}
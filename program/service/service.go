@@ -8,31 +8,30 @@ import (
 	"image"
 	"image/color"
 	"image/draw"
-	"image/jpeg"
-	"image/png"
 	"io"
 	"io/ioutil"
 	"net/http"
-	"os"
-	"strings"
-	"sync"
+	"sort"
 	"time"
 
 	"code.google.com/p/graphics-go/graphics"
 	"github.com/anthonynsimon/bild/transform"
 	"github.com/fogleman/gg"
-	"github.com/golang/freetype"
-	"github.com/golang/freetype/truetype"
 	"github.com/nfnt/resize"
 	"github.com/shiguanghuxian/poster/program/common"
 	"github.com/shiguanghuxian/poster/program/logger"
 	qrcode "github.com/skip2/go-qrcode"
+	"golang.org/x/image/math/fixed"
 )
 
 // Service 具体生成海报业务代码
 type Service struct {
-	Param *PosterParam // 绘图参数
-	rgba  *image.RGBA  // 绘制图片对象
+	Param    *PosterParam // 绘图参数
+	Overflow []string     // 超出文本框的文本在请求参数中的位置，如 texts[0]、layers[2]
+	rgba     *image.RGBA  // 绘制图片对象
+	layers   []*Layer     // 按绘制顺序排列的图层
+	scale    float64      // 绘制倍数 - 参数已按倍数缩放，平铺和居中的图片需要缩放
+	sources  *sourceCache // 下载和解析后的图片 - 按多个倍数绘制时共用
 }
 
 // NewService 创建绘图对象 - 检查参数
@@ -48,94 +47,351 @@ func NewService(param *PosterParam) (s *Service, err error) {
 	if param.Height == 0 {
 		param.Height = DefaultHeight
 	}
-	// 背景
+	if param.Width < 0 || param.Height < 0 {
+		err = errors.New("The poster width and height cannot be negative")
+		return
+	}
+	// 绘制倍数
+	if err = checkScale(param); err != nil {
+		return
+	}
+	// 输出图片参数
+	if param.Output == nil {
+		param.Output = &Output{}
+	}
+	if err = checkOutput(param.Output); err != nil {
+		return
+	}
+	// 背景 - 不传时为白色，输出格式支持透明时为透明
 	if param.Background == nil {
-		err = errors.New("The background cannot be nil")
+		param.Background = &Background{}
+		if param.Output.transparent() == false {
+			param.Background.Color = "#FFFFFF"
+		}
+	} else if err = checkBackground(param.Background); err != nil {
 		return
 	}
-	if len(param.Background.Image) == 0 && param.Background.ImageURL == "" {
-		err = errors.New("The background image url address and background image base64 value cannot be empty")
+	// 布局模式 - 未指定时旧版本请求保持原有位置，只使用layers时精确定位
+	if param.LayoutMode == 0 {
+		if len(param.Layers) > 0 && param.hasLegacyObjects() == false {
+			param.LayoutMode = LayoutModeExact
+		} else {
+			param.LayoutMode = LayoutModeLegacy
+		}
+	}
+	if param.LayoutMode != LayoutModeLegacy && param.LayoutMode != LayoutModeExact {
+		err = fmt.Errorf("Unsupported layout mode -- %d", param.LayoutMode)
 		return
 	}
-	if param.Background.ImageType == "" {
-		param.Background.ImageType = "jpg"
+	// 图层 - 合并文本、子图片、二维码、小程序码并检查参数
+	layers, err := newLayers(param)
+	if err != nil {
+		return
 	}
-	// 文本
-	if len(param.Texts) > 0 {
-		for _, txt := range param.Texts {
-			if txt.Content == "" {
-				err = errors.New("An empty string exists for the text to be written")
-				return
-			}
-			if txt.LineCount == 0 {
-				txt.LineCount = 1
-			}
-			if txt.FontColor == "" {
-				txt.FontColor = "#000000"
-			}
-			if txt.FontSize == 0 {
-				txt.FontSize = 24.0
-			}
-			if txt.LineHeight == 0 {
-				txt.LineHeight = 1.5
-			}
-			if txt.FontName == "" {
-				txt.FontName = "default.ttc"
-			}
+
+	s = &Service{
+		Param:   param,
+		layers:  layers,
+		sources: newSourceCache(),
+	}
+	return
+}
+
+// 检查位置、大小等子对象公共参数
+func checkSubObject(so *SubObject) (err error) {
+	if so.Width < 0 || so.Height < 0 {
+		return errors.New("The width and height cannot be negative")
+	}
+	if so.Opacity != nil && (*so.Opacity < 0 || *so.Opacity > 1) {
+		return errors.New("The opacity must be between 0 and 1")
+	}
+	if so.Mask != nil {
+		if err = checkMask(so.Mask); err != nil {
+			return
 		}
 	}
-	// 子图片
-	if len(param.SubImages) > 0 {
-		for _, subImage := range param.SubImages {
-			if len(subImage.Image) == 0 && subImage.ImageURL == "" {
-				err = errors.New("SubImage exists image url and image base64 are both empty")
-				return
-			}
+	return checkAnchor(so.Anchor)
+}
+
+// opacity 不透明度 - 不传时为1
+func (so *SubObject) opacity() float64 {
+	if so.Opacity == nil {
+		return 1
+	}
+	return *so.Opacity
+}
+
+// checkColors 检查颜色 - 为空表示使用默认值或不绘制，name为参数名
+func checkColors(colors ...string) (err error) {
+	for i := 0; i+1 < len(colors); i += 2 {
+		if colors[i+1] == "" {
+			continue
+		}
+		if _, err = common.HexToColor(colors[i+1]); err != nil {
+			return fmt.Errorf("Invalid %s -- %s", colors[i], colors[i+1])
 		}
 	}
+	return
+}
 
-	// 子二维码
-	if len(param.SubQrCode) > 0 {
-		for _, subQrCode := range param.SubQrCode {
-			if subQrCode.Content == "" {
-				err = errors.New("QRcode content cannot be empty")
+// 检查背景参数并设置默认值
+func checkBackground(bg *Background) (err error) {
+	if len(bg.Image) == 0 && bg.ImageURL == "" && bg.Color == "" && bg.Gradient == nil {
+		return errors.New("The background requires an image, a color or a gradient")
+	}
+	if bg.Color != "" {
+		if _, err = common.HexToColor(bg.Color); err != nil {
+			return fmt.Errorf("Background color %v", err)
+		}
+	}
+	if bg.Gradient != nil {
+		if err = checkGradient(bg.Gradient); err != nil {
+			return
+		}
+	}
+	if err = checkImageType(&bg.ImageType); err != nil {
+		return
+	}
+	return checkFit(&bg.Fit, bg.FocalPoint)
+}
+
+// 检查文本参数并设置默认值
+func checkText(txt *Text) (err error) {
+	if err = checkSubObject(&txt.SubObject); err != nil {
+		return
+	}
+	if txt.Content == "" && len(txt.Spans) == 0 {
+		return errors.New("An empty string exists for the text to be written")
+	}
+	for i, span := range txt.Spans {
+		if span == nil {
+			return fmt.Errorf("spans[%d]: The text span cannot be empty", i)
+		}
+		if span.FontSize < 0 {
+			return fmt.Errorf("spans[%d]: The font size cannot be negative", i)
+		}
+	}
+	if txt.LineCount < 0 {
+		return errors.New("The line count cannot be negative")
+	}
+	switch txt.Align {
+	case "", AlignLeft, AlignCenter, AlignRight, AlignJustify:
+	default:
+		return fmt.Errorf("Unsupported text align -- %s", txt.Align)
+	}
+	switch txt.VerticalAlign {
+	case "", VerticalAlignTop, VerticalAlignMiddle, VerticalAlignBottom:
+	default:
+		return fmt.Errorf("Unsupported text vertical align -- %s", txt.VerticalAlign)
+	}
+	switch txt.Overflow {
+	case "":
+		txt.Overflow = OverflowClip
+	case OverflowClip, OverflowEllipsis, OverflowShrink:
+	default:
+		return fmt.Errorf("Unsupported text overflow -- %s", txt.Overflow)
+	}
+	if txt.FontColor == "" {
+		txt.FontColor = "#000000"
+	}
+	if txt.FontSize == 0 {
+		txt.FontSize = 24.0
+	}
+	if txt.MinFontSize == 0 {
+		txt.MinFontSize = DefaultMinFontSize
+	}
+	if txt.MinFontSize > txt.FontSize {
+		txt.MinFontSize = txt.FontSize
+	}
+	if txt.LineHeight == 0 {
+		txt.LineHeight = 1.5
+	}
+	if txt.FontName == "" {
+		txt.FontName = "default.ttc"
+	}
+	// 描边、阴影、背景
+	if txt.Stroke != nil {
+		if txt.Stroke.Color == "" {
+			txt.Stroke.Color = "#FFFFFF"
+		}
+		if txt.Stroke.Width == 0 {
+			txt.Stroke.Width = 2
+		}
+		if txt.Stroke.Width < 0 || txt.Stroke.Width > MaxTextStrokeWidth {
+			return fmt.Errorf("The text stroke width must be between 0 and %d", MaxTextStrokeWidth)
+		}
+	}
+	if txt.Fill != nil {
+		if txt.Fill.Gradient != nil {
+			if err = checkGradient(txt.Fill.Gradient); err != nil {
 				return
 			}
-			if subQrCode.BackgroundColor == "" {
-				subQrCode.BackgroundColor = "#FFFFFF"
-			}
-			if subQrCode.ForegroundColor == "" {
-				subQrCode.ForegroundColor = "#000000"
-			}
-			if subQrCode.Width == 0 {
-				subQrCode.Width = 100
-			}
+		} else if len(txt.Fill.Image) == 0 && txt.Fill.ImageURL == "" {
+			return errors.New("Text fill image url and image base64 are both empty")
 		}
 	}
+	if txt.Mask != nil && (txt.Width <= 0 || txt.Height <= 0) {
+		return errors.New("The text width and height are required when using a mask")
+	}
+	if txt.Shadow != nil {
+		if txt.Shadow.Color == "" {
+			txt.Shadow.Color = "#00000080"
+		}
+		if txt.Shadow.Blur < 0 || txt.Shadow.Blur > MaxTextShadowBlur {
+			return fmt.Errorf("The text shadow blur must be between 0 and %d", MaxTextShadowBlur)
+		}
+	}
+	if txt.Background != nil {
+		if txt.Background.Color == "" {
+			return errors.New("The text background color cannot be empty")
+		}
+		switch txt.Background.Mode {
+		case "":
+			txt.Background.Mode = TextBackgroundBlock
+		case TextBackgroundBlock, TextBackgroundLine:
+		default:
+			return fmt.Errorf("Unsupported text background mode -- %s", txt.Background.Mode)
+		}
+		if err = checkColors("text background color", txt.Background.Color); err != nil {
+			return
+		}
+	}
+	if txt.Stroke != nil {
+		if err = checkColors("text stroke color", txt.Stroke.Color); err != nil {
+			return
+		}
+	}
+	if txt.Shadow != nil {
+		if err = checkColors("text shadow color", txt.Shadow.Color); err != nil {
+			return
+		}
+	}
+	for i, span := range txt.Spans {
+		if err = checkColors(fmt.Sprintf("spans[%d] font color", i), span.FontColor); err != nil {
+			return
+		}
+	}
+	return checkColors("font color", txt.FontColor)
+}
 
-	// 子小程序码
-	if len(param.SubWxQrCode) > 0 {
-		for _, subWxQrCode := range param.SubWxQrCode {
-			if subWxQrCode.AccessToken == "" {
-				return nil, errors.New("小程序码生成，AccessToken参数不能为空")
-			}
-			if subWxQrCode.Width == 0 {
-				subWxQrCode.Width = 100
-			}
-			if subWxQrCode.LineColor == "" {
-				subWxQrCode.LineColor = "#000000"
-			}
+// 检查子图片参数
+func checkImage(subImage *Image) (err error) {
+	if err = checkSubObject(&subImage.SubObject); err != nil {
+		return
+	}
+	if len(subImage.Image) == 0 && subImage.ImageURL == "" {
+		return errors.New("SubImage exists image url and image base64 are both empty")
+	}
+	if err = checkImageType(&subImage.ImageType); err != nil {
+		return
+	}
+	if err = checkFit(&subImage.Fit, subImage.FocalPoint); err != nil {
+		return
+	}
+	switch subImage.Shape {
+	case "", ShapeRect, ShapeCircle, ShapeEllipse:
+	default:
+		return fmt.Errorf("Unsupported image shape -- %s", subImage.Shape)
+	}
+	if err = subImage.BorderRadius.check(); err != nil {
+		return
+	}
+	if subImage.Border != nil {
+		if subImage.Border.Color == "" {
+			subImage.Border.Color = "#FFFFFF"
+		}
+		if subImage.Border.Width == 0 {
+			subImage.Border.Width = DefaultBorderWidth
+		}
+		if subImage.Border.Width < 0 {
+			return errors.New("The border width cannot be negative")
+		}
+		if err = checkColors("border color", subImage.Border.Color); err != nil {
+			return
 		}
 	}
+	return checkColors("image background color", subImage.Color)
+}
 
-	s = &Service{
-		Param: param,
+// 检查二维码参数并设置默认值
+func checkQrCode(subQrCode *QrCode) (err error) {
+	if err = checkSubObject(&subQrCode.SubObject); err != nil {
+		return
 	}
-	return
+	if subQrCode.Content == "" {
+		return errors.New("QRcode content cannot be empty")
+	}
+	if subQrCode.BackgroundColor == "" {
+		subQrCode.BackgroundColor = "#FFFFFF"
+	}
+	if subQrCode.ForegroundColor == "" {
+		subQrCode.ForegroundColor = "#000000"
+	}
+	if subQrCode.Width == 0 {
+		subQrCode.Width = 100
+	}
+	return checkColors("QRcode background color", subQrCode.BackgroundColor, "QRcode foreground color", subQrCode.ForegroundColor)
 }
 
-// DrawPoster 生成海报
+// 检查小程序码参数并设置默认值
+func checkWxQrCode(subWxQrCode *WxQrCode) (err error) {
+	if err = checkSubObject(&subWxQrCode.SubObject); err != nil {
+		return
+	}
+	if subWxQrCode.AccessToken == "" {
+		return errors.New("小程序码生成，AccessToken参数不能为空")
+	}
+	if subWxQrCode.Width == 0 {
+		subWxQrCode.Width = 100
+	}
+	if subWxQrCode.LineColor == "" {
+		subWxQrCode.LineColor = "#000000"
+	}
+	return checkColors("line color", subWxQrCode.LineColor)
+}
+
+// DrawPoster 生成海报 - 按Scale倍数绘制
 func (s *Service) DrawPoster() (img []byte, err error) {
+	ss := s.scaled(s.Param.Scale)
+	img, err = ss.draw()
+	s.Overflow = ss.Overflow
+	return
+}
+
+// DrawPosters 按Scales中的每个倍数生成海报，未设置Scales时只按Scale生成一张
+func (s *Service) DrawPosters() (images []*ScaledImage, err error) {
+	scales := s.Param.Scales
+	if len(scales) == 0 {
+		scales = []float64{s.Param.Scale}
+	}
+	// 图片只获取一次，先绘制最大的倍数，小程序码按最大的宽度请求，其他倍数缩小
+	order := make([]int, len(scales))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return scales[order[i]] > scales[order[j]]
+	})
+	images = make([]*ScaledImage, len(scales))
+	for _, i := range order {
+		ss := s.scaled(scales[i])
+		img, err := ss.draw()
+		if err != nil {
+			return nil, err
+		}
+		images[i] = &ScaledImage{Scale: scales[i], Width: ss.Param.Width, Height: ss.Param.Height, Image: img}
+		// 超出文本框的文本合并去重
+		for _, path := range ss.Overflow {
+			if containsString(s.Overflow, path) == false {
+				s.Overflow = append(s.Overflow, path)
+			}
+		}
+	}
+	return
+}
+
+// draw 绘制并编码图片
+func (s *Service) draw() (img []byte, err error) {
 	startTime := time.Now()
 	defer func() {
 		if err := recover(); err != nil {
@@ -147,284 +403,390 @@ func (s *Service) DrawPoster() (img []byte, err error) {
 
 	/* 生成画布 */
 	s.rgba = image.NewRGBA(image.Rect(0, 0, s.Param.Width, s.Param.Height))
-	// 背景图片拉伸至画布大小
-	backgroundImgReader, err := s.getBackgroundImg(s.Param.Background.Image, s.Param.Background.ImageURL)
-	if err != nil {
-		logger.Log.Errorw("获取背景图错误1", "err", err)
-		return
-	}
-	var backgroundImg image.Image
-	if s.Param.Background.ImageType == "png" {
-		backgroundImg, err = png.Decode(backgroundImgReader)
-	} else if s.Param.Background.ImageType == "jpg" || s.Param.Background.ImageType == "jpeg" {
-		backgroundImg, err = jpeg.Decode(backgroundImgReader)
-	} else {
-		logger.Log.Warnw("背景图片，不支持的图片格式类型，格式必须是png或jpg，格式不带点", "ImageType", s.Param.Background.ImageType)
-		err = errors.New("Unsupported image types -- " + s.Param.Background.ImageType)
-		return
-	}
+	// 背景
+	err = s.drawBackground()
 	if err != nil {
-		logger.Log.Errorw("获取背景图错误2", "err", err)
 		return
 	}
-	// 缩放到画布大小
-	picResized := resize.Resize(uint(s.Param.Width), uint(s.Param.Height), backgroundImg, resize.Lanczos3)
-	// 拉伸至中心完全显示
-	draw.Draw(s.rgba, image.Rect(0, 0, s.Param.Width, s.Param.Height), picResized,
-		image.Point{int((picResized.Bounds().Dx() - s.Param.Width) / 2), int((picResized.Bounds().Dy() - s.Param.Height) / 2)},
-		draw.Src)
 
-	/* 添加子图片 */
-	err = s.drawSubImages()
-	if err != nil {
-		return nil, err
+	/* 按层级绘制图层 */
+	for _, l := range s.layers {
+		err = s.drawLayer(l)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	/* 添加二维码 */
-	err = s.drawSubQrCodes()
+	// 输出图片到字节
+	outImg := s.rgba.SubImage(s.rgba.Bounds())
+	f := bytes.NewBuffer(make([]byte, 0))
+	err = s.Param.Output.encode(f, outImg)
 	if err != nil {
 		return nil, err
 	}
 
-	/* 添加小程序码 */
-	err = s.drawSubWxQrCodes()
+	return ioutil.ReadAll(f)
+}
+
+// 绘制背景 - 依次绘制背景色、渐变，再将背景图片按适配方式绘制在上面，图片透明部分显示下面的颜色
+func (s *Service) drawBackground() (err error) {
+	bg := s.Param.Background
+	if bg.Color != "" {
+		bgColor, _ := common.HexToColor(bg.Color)
+		draw.Draw(s.rgba, s.rgba.Bounds(), image.NewUniform(bgColor), image.ZP, draw.Src)
+	}
+	if bg.Gradient != nil {
+		draw.Draw(s.rgba, s.rgba.Bounds(), bg.Gradient.image(s.rgba.Bounds()), s.rgba.Bounds().Min, draw.Over)
+	}
+	if len(bg.Image) == 0 && bg.ImageURL == "" {
+		return
+	}
+	backgroundImg, err := s.loadImage(bg.Image, bg.ImageURL, bg.ImageType)
 	if err != nil {
-		return nil, err
+		logger.Log.Errorw("获取背景图错误", "err", err, "ImageType", bg.ImageType)
+		return
 	}
+	// 按适配方式放入画布，空白部分显示背景色和渐变
+	backgroundImg = s.scaleFitImage(backgroundImg, bg.Fit)
+	picResized := fitImage(backgroundImg, s.Param.Width, s.Param.Height, bg.Fit, bg.FocalPoint, nil)
+	draw.Draw(s.rgba, s.rgba.Bounds(), picResized, picResized.Bounds().Min, draw.Over)
+	return
+}
 
-	/* 添加文本 */
-	err = s.drawSubTexts()
+// 绘制单个图层 - 半透明或有蒙版的图层先绘制到独立的透明画布，再按不透明度和蒙版合成到主图
+func (s *Service) drawLayer(l *Layer) (err error) {
+	so := l.subObject()
+	opacity := so.opacity()
+	dst := s.rgba
+	if opacity < 1 || so.Mask != nil {
+		dst = image.NewRGBA(s.rgba.Bounds())
+	}
+	switch l.Type {
+	case LayerTypeText:
+		err = s.drawSubText(dst, l.path, l.Text)
+	case LayerTypeImage:
+		err = s.drawSubImage(dst, l.path, l.Image)
+	case LayerTypeQrCode:
+		err = s.drawSubQrCode(dst, l.path, l.QrCode)
+	case LayerTypeWxQrCode:
+		err = s.drawSubWxQrCode(dst, l.path, l.WxQrCode)
+	case LayerTypeShape:
+		err = s.drawShape(dst, l.path, l.Shape)
+	}
 	if err != nil {
-		return nil, err
+		return
 	}
-
-	// 输出图片到字节
-	outImg := s.rgba.SubImage(s.rgba.Bounds())
-	f := bytes.NewBuffer(make([]byte, 0))
-	err = jpeg.Encode(f, outImg, nil)
+	if dst == s.rgba {
+		return
+	}
+	if so.Mask == nil {
+		drawOver(s.rgba, dst.Bounds(), dst, dst.Bounds().Min, opacity)
+		return
+	}
+	maskImg, err := s.loadImage(so.Mask.Image, so.Mask.ImageURL, "")
 	if err != nil {
-		return nil, err
+		logger.Log.Errorw("获取蒙版图片错误", "err", err, "subKey", l.path)
+		return
 	}
-
-	return ioutil.ReadAll(f)
+	mask := newAlphaMask(maskImg, l.bounds(s.legacyLayout()), so.Mask.Mode, opacity)
+	draw.DrawMask(s.rgba, mask.Bounds(), dst, mask.Bounds().Min, mask, mask.Bounds().Min, draw.Over)
+	return
 }
 
 // 绘制子图片
-func (s *Service) drawSubImages() (err error) {
-	for subKey, subImg := range s.Param.SubImages {
-		sub, err := s.getBackgroundImg(subImg.Image, subImg.ImageURL)
+func (s *Service) drawSubImage(dst *image.RGBA, subKey string, subImg *Image) (err error) {
+	subImage, err := s.loadImage(subImg.Image, subImg.ImageURL, subImg.ImageType)
+	if err != nil {
+		logger.Log.Errorw("解析子图错误", "err", err, "subKey", subKey, "ImageType", subImg.ImageType)
+		return err
+	}
+	var subBColor color.Color
+	if subImg.Color != "" {
+		subBColor, err = common.HexToColor(subImg.Color)
 		if err != nil {
-			logger.Log.Errorw("解析子图错误1", "err", err, "subKey", subKey)
+			logger.Log.Errorw("子图背景色解析错误", "err", err, "subKey", subKey, "subBColor", subImg.Color)
 			return err
 		}
-		var subImage image.Image
-		imageType := strings.ToLower(subImg.ImageType)
-		if imageType == "png" {
-			subImage, err = png.Decode(sub)
-		} else if imageType == "jpg" || imageType == "jpeg" {
-			subImage, err = jpeg.Decode(sub)
-		} else {
-			logger.Log.Warnw("不支持的图片格式类型，格式必须是png或jpg，格式不带点", "err", err, "subKey", subKey)
-			continue
-		}
+	}
+	// 图片缩放 - 按适配方式放入去掉内边距后的区域，空白部分使用背景色
+	subImage = fitImage(s.scaleFitImage(subImage, subImg.Fit), subImg.Width-subImg.Padding, subImg.Height-subImg.Padding, subImg.Fit, subImg.FocalPoint, subBColor)
+
+	// 旋转
+	if subImg.Angle != 0 {
+		subImage, err = rotateImage(subImage, subImg.Width, subImg.Height, subImg.Angle, subBColor)
 		if err != nil {
-			logger.Log.Errorw("解析子图错误2", "err", err, "subKey", subKey)
+			logger.Log.Errorw("图片旋转错误", "err", err, "subKey", subKey, "method", "drawSubImage")
 			return err
 		}
-		// 图片缩放
-		subImage = resize.Resize(uint(subImg.Width-subImg.Padding), uint(subImg.Height-subImg.Padding), subImage, resize.Lanczos3)
-
-		// 旋转
-		if subImg.Angle != 0 {
-			dst := image.NewCMYK(image.Rect(0, 0, subImg.Width, subImg.Height))
-			if subImg.Color != "" {
-				subBColor, err := common.HexToColor(subImg.Color)
-				if err != nil {
-					logger.Log.Errorw("子图背景色解析错误", "err", err, "subKey", subKey, "subBColor", subBColor)
-					return err
-				}
-				for x := 0; x < dst.Bounds().Dx(); x++ {
-					for y := 0; y < dst.Bounds().Dy(); y++ {
-						dst.Set(x, y, subBColor)
-					}
-				}
-			}
-			err = graphics.Rotate(dst, subImage, &graphics.RotateOptions{gg.Radians(subImg.Angle)})
-			if err != nil {
-				logger.Log.Errorw("图片旋转错误", "err", err, "subKey", subKey, "method", "drawSubQrCodes")
-				return err
-			}
-			subImage = dst
-		}
-		imgResized := transform.Resize(subImage, subImg.Width, subImg.Height, transform.Linear)
-		draw.Draw(s.rgba,
-			image.Rectangle{
-				image.Point{subImg.Left + subImg.Width/2, subImg.Top + subImg.Height/2},
-				s.rgba.Bounds().Max,
-			},
-			imgResized,
-			image.Point{0, 0},
-			draw.Src)
 	}
+	imgResized := transform.Resize(subImage, subImg.Width, subImg.Height, transform.Linear)
+	// 圆角、圆形裁剪和边框
+	clipped, err := subImg.clipShape(imgResized)
+	if err != nil {
+		logger.Log.Errorw("图片形状裁剪错误", "err", err, "subKey", subKey)
+		return err
+	}
+	drawOver(dst,
+		subImg.bounds(subImg.Width, subImg.Height, s.legacyLayout()),
+		clipped,
+		image.Point{0, 0},
+		1)
 	return
 }
 
 // 绘制文本
-func (s *Service) drawSubTexts() (err error) {
-	for _, txt := range s.Param.Texts {
-		// 换行后的文本
-		texts := txt.GetTest()
-		// log.Println(texts)
-		// 字体
-		font, err := s.getFont(txt.FontName)
-		if err != nil {
-			logger.Log.Errorw("获取字体错误", "err", err)
-			return err
-		}
-		c := freetype.NewContext()
-		c.SetFont(font)
-		c.SetFontSize(txt.FontSize)
-		c.SetClip(image.Rect(txt.Left, txt.Top, txt.Left+txt.Width, txt.Top+txt.Height)) //文字区域
-		c.SetDst(s.rgba)
-		// 字体颜色
-		fontColor, err := common.HexToColor(txt.FontColor)
-		if err != nil {
-			logger.Log.Errorw("解析字体颜色错误", "err", err, "FontColor", txt.FontColor)
-			return err
-		}
-		c.SetSrc(image.NewUniform(fontColor))
+func (s *Service) drawSubText(dst *image.RGBA, k string, txt *Text) (err error) {
+	// 换行后的文本
+	layout, err := s.layoutText(txt)
+	if err != nil {
+		logger.Log.Errorw("文本排版错误", "err", err, "subKey", k)
+		return err
+	}
+	if layout.overflow == true {
+		logger.Log.Debugw("文本超出文本框", "subKey", k, "overflow", txt.Overflow, "fontSize", layout.fontSize)
+		s.Overflow = append(s.Overflow, k)
+	}
 
-		pt := freetype.Pt(txt.Left, txt.Top+int(c.PointToFixed(txt.FontSize)>>6))
-		for _, t := range texts {
-			c.DrawString(t, pt)
-			pt.Y += c.PointToFixed(txt.FontSize * txt.LineHeight)
+	// 文字区域 - 未设置宽高时不限制
+	box := txt.bounds(txt.Width, txt.Height, false)
+	clip := box
+	if txt.Width <= 0 {
+		clip.Max.X = dst.Bounds().Max.X
+	}
+	if txt.Height <= 0 {
+		clip.Max.Y = dst.Bounds().Max.Y
+	}
+
+	// 先将文字绘制到单独的图层和蒙版，再依次绘制背景、阴影、描边和文字
+	top := box.Min.Y + layout.verticalOffset(txt)
+	blockWidth := fixed.I(txt.Width)
+	if txt.Width <= 0 {
+		blockWidth = maxLineWidth(layout.lines)
+	}
+	area := clip.Inset(-txt.effectMargin()).Intersect(dst.Bounds())
+	fill := image.NewRGBA(area)
+	mask := image.NewAlpha(area)
+	lineBoxes := make([]image.Rectangle, 0, len(layout.lines))
+	for i, baseline := range baselines(layout.lines, txt.LineHeight) {
+		line := layout.lines[i]
+		glyphs, offset := alignLine(line, blockWidth, txt.Align)
+		dot := fixed.P(box.Min.X, top+baseline)
+		dot.X += offset
+		drawGlyphs(fill, mask, clip, dot, glyphs)
+		lineBoxes = append(lineBoxes, image.Rect(
+			dot.X.Floor(), (dot.Y-line.ascent).Floor(),
+			(dot.X+glyphsWidth(glyphs)).Ceil(), (dot.Y+line.descent).Ceil(),
+		))
+	}
+	err = drawTextEffects(dst, txt, mask, lineBoxes)
+	if err != nil {
+		logger.Log.Errorw("绘制文字效果错误", "err", err, "subKey", k)
+		return err
+	}
+	if txt.Fill == nil {
+		draw.Draw(dst, fill.Bounds(), fill, fill.Bounds().Min, draw.Over)
+		return
+	}
+	// 图片填充 - 覆盖文本框，未设置宽高时覆盖文字所在区域
+	fillArea := box
+	if txt.Width <= 0 || txt.Height <= 0 {
+		fillArea = image.Rectangle{}
+		for _, r := range lineBoxes {
+			fillArea = fillArea.Union(r)
 		}
 	}
+	if txt.Fill.Gradient != nil {
+		draw.DrawMask(dst, fillArea, txt.Fill.Gradient.image(fillArea), fillArea.Min, mask, fillArea.Min, draw.Over)
+		return
+	}
+	fillImg, err := s.loadImage(txt.Fill.Image, txt.Fill.ImageURL, "")
+	if err != nil {
+		logger.Log.Errorw("获取文字填充图片错误", "err", err, "subKey", k)
+		return err
+	}
+	fillImg = fitImage(fillImg, fillArea.Dx(), fillArea.Dy(), FitCover, nil, nil)
+	draw.DrawMask(dst, fillArea, fillImg, fillImg.Bounds().Min, mask, fillArea.Min, draw.Over)
 	return
 }
 
 // 绘制二维码
-func (s *Service) drawSubQrCodes() (err error) {
-	for k, v := range s.Param.SubQrCode {
-		// 生成二维码
-		qr, err := qrcode.New(v.Content, qrcode.High)
-		if err != nil {
-			logger.Log.Errorw("生成二维码错误", "err", err)
-			return err
-		}
-		backgroundColor, err := common.HexToColor(v.BackgroundColor)
-		if err != nil {
-			logger.Log.Errorw("解析二维码背景色错误", "err", err)
-			return err
-		}
-		foregroundColor, err := common.HexToColor(v.ForegroundColor)
+func (s *Service) drawSubQrCode(dst *image.RGBA, k string, v *QrCode) (err error) {
+	// 生成二维码
+	qr, err := qrcode.New(v.Content, qrcode.High)
+	if err != nil {
+		logger.Log.Errorw("生成二维码错误", "err", err)
+		return err
+	}
+	backgroundColor, err := common.HexToColor(v.BackgroundColor)
+	if err != nil {
+		logger.Log.Errorw("解析二维码背景色错误", "err", err)
+		return err
+	}
+	foregroundColor, err := common.HexToColor(v.ForegroundColor)
+	if err != nil {
+		logger.Log.Errorw("解析二维码前景色错误", "err", err)
+		return err
+	}
+	qr.BackgroundColor = backgroundColor
+	qr.ForegroundColor = foregroundColor
+	qrImg := qr.Image(v.Width)
+	// 旋转图片
+	if v.Angle != 0 {
+		qrImg, err = rotateImage(qrImg, v.Width, v.Width, v.Angle, nil)
 		if err != nil {
-			logger.Log.Errorw("解析二维码前景色错误", "err", err)
+			logger.Log.Errorw("图片旋转错误", "err", err, "subKey", k, "method", "drawSubQrCode")
 			return err
 		}
-		qr.BackgroundColor = backgroundColor
-		qr.ForegroundColor = foregroundColor
-		qrImg := qr.Image(v.Width)
-		// 旋转图片
-		if v.Angle != 0 {
-			dst := image.NewCMYK(image.Rect(0, 0, v.Width, v.Width))
-			err = graphics.Rotate(dst, qrImg, &graphics.RotateOptions{gg.Radians(v.Angle)})
-			if err != nil {
-				logger.Log.Errorw("图片旋转错误", "err", err, "subKey", k, "method", "drawSubQrCodes")
-				return err
-			}
-			qrImg = dst
-		}
-		// 绘入主图
-		draw.Draw(s.rgba,
-			image.Rectangle{
-				image.Point{v.Left + v.Width/2, v.Top + v.Width/2},
-				s.rgba.Bounds().Max,
-			},
-			qrImg,
-			image.Point{0, 0},
-			draw.Src)
 	}
+	// 绘入主图
+	drawOver(dst,
+		v.bounds(v.Width, v.Width, s.legacyLayout()),
+		qrImg,
+		image.Point{0, 0},
+		1)
 	return
 }
 
 // 绘制小程序码
-func (s *Service) drawSubWxQrCodes() (err error) {
-	for k, v := range s.Param.SubWxQrCode {
-		lineColor, err := common.HexToColor(v.LineColor)
-		if err != nil {
-			logger.Log.Errorw("解析小程序码颜色错误", "err", err)
-			return err
-		}
-		lineColorRGB := lineColor.(color.RGBA)
-		req := map[string]interface{}{
-			"scene":      v.Scene,
-			"page":       v.Page,
-			"width":      v.Width,
-			"auto_color": v.AutoColor,
-			"line_color": map[string]int{
-				"r": int(lineColorRGB.R),
-				"g": int(lineColorRGB.G),
-				"b": int(lineColorRGB.B),
-			},
-			"is_hyaline": v.IsHyaline,
-		}
-		reqRed, _ := json.Marshal(req)
-		wxQrCodeUrl := "https://api.weixin.qq.com/wxa/getwxacodeunlimit?access_token=" + v.AccessToken
-		// 请求接口生成小程序码
-		resp, err := http.Post(wxQrCodeUrl, "application/json", bytes.NewReader(reqRed))
-		if err != nil {
-			logger.Log.Errorw("请求获取小程序码错误", "err", err)
-			return err
-		}
+func (s *Service) drawSubWxQrCode(dst *image.RGBA, k string, v *WxQrCode) (err error) {
+	qrImg, err := s.sources.get(wxQrCodeSourceKey{layer: k}, func() (image.Image, error) {
+		return getWxQrCode(v)
+	})
+	if err != nil {
+		return err
+	}
+	// 图片缩放
+	qrImg = resize.Resize(uint(v.Width), uint(v.Width), qrImg, resize.Lanczos3)
 
-		// copy body 如果是json证明可能遇到了错误
-		wxBody := bytes.NewBuffer(make([]byte, 0))
-		_, err = io.Copy(wxBody, resp.Body)
+	// 旋转图片
+	if v.Angle != 0 {
+		qrImg, err = rotateImage(qrImg, v.Width, v.Width, v.Angle, nil)
 		if err != nil {
-			logger.Log.Errorw("复制微信生成小程序码错误", "err", err)
+			logger.Log.Errorw("图片旋转错误", "err", err, "subKey", k, "method", "drawSubWxQrCode")
 			return err
 		}
-		wxErr := make(map[string]interface{}, 0)
-		err = json.Unmarshal(wxBody.Bytes(), &wxErr)
-		if err == nil {
-			err = fmt.Errorf("errcode: %v, errmsg: %v", wxErr["errcode"], wxErr["errmsg"])
-			logger.Log.Errorw("调用微信生成小程序码错误", "err", err)
-			return err
-		}
-		err = nil
-		// 解析为图片
-		qrImg, err := jpeg.Decode(wxBody)
-		if err != nil {
-			logger.Log.Errorw("小程序码返回body解析错误", "err", err)
-			return err
-		}
-		// 图片缩放
-		qrImg = resize.Resize(uint(v.Width), uint(v.Width), qrImg, resize.Lanczos3)
+	}
+	// 绘入主图
+	drawOver(dst,
+		v.bounds(qrImg.Bounds().Dx(), qrImg.Bounds().Dy(), s.legacyLayout()),
+		qrImg,
+		image.Point{0, 0},
+		1)
+	return
+}
 
-		// 旋转图片
-		if v.Angle != 0 {
-			dst := image.NewCMYK(image.Rect(0, 0, v.Width, v.Width))
-			err = graphics.Rotate(dst, qrImg, &graphics.RotateOptions{gg.Radians(v.Angle)})
-			if err != nil {
-				logger.Log.Errorw("图片旋转错误", "err", err, "subKey", k, "method", "drawSubWxQrCodes")
-				return err
-			}
-			qrImg = dst
-		}
-		// 绘入主图
-		draw.Draw(s.rgba,
-			image.Rectangle{
-				image.Point{v.Left + qrImg.Bounds().Dx()/2, v.Top + qrImg.Bounds().Dy()/2},
-				s.rgba.Bounds().Max,
-			},
-			qrImg,
-			image.Point{0, 0},
-			draw.Src)
+// wxQrCodeAPI 微信生成小程序码接口
+var wxQrCodeAPI = "https://api.weixin.qq.com/wxa/getwxacodeunlimit"
+
+// getWxQrCode 请求微信接口生成小程序码
+func getWxQrCode(v *WxQrCode) (qrImg image.Image, err error) {
+	lineColor, err := common.HexToColor(v.LineColor)
+	if err != nil {
+		logger.Log.Errorw("解析小程序码颜色错误", "err", err)
+		return nil, err
+	}
+	lineColorRGB := lineColor.(color.RGBA)
+	req := map[string]interface{}{
+		"scene":      v.Scene,
+		"page":       v.Page,
+		"width":      v.Width,
+		"auto_color": v.AutoColor,
+		"line_color": map[string]int{
+			"r": int(lineColorRGB.R),
+			"g": int(lineColorRGB.G),
+			"b": int(lineColorRGB.B),
+		},
+		"is_hyaline": v.IsHyaline,
+	}
+	reqRed, _ := json.Marshal(req)
+	wxQrCodeUrl := wxQrCodeAPI + "?access_token=" + v.AccessToken
+	// 请求接口生成小程序码
+	resp, err := http.Post(wxQrCodeUrl, "application/json", bytes.NewReader(reqRed))
+	if err != nil {
+		logger.Log.Errorw("请求获取小程序码错误", "err", err)
+		return nil, err
+	}
+
+	// copy body 如果是json证明可能遇到了错误
+	wxBody := bytes.NewBuffer(make([]byte, 0))
+	_, err = io.Copy(wxBody, resp.Body)
+	if err != nil {
+		logger.Log.Errorw("复制微信生成小程序码错误", "err", err)
+		return nil, err
+	}
+	wxErr := make(map[string]interface{}, 0)
+	err = json.Unmarshal(wxBody.Bytes(), &wxErr)
+	if err == nil {
+		err = fmt.Errorf("errcode: %v, errmsg: %v", wxErr["errcode"], wxErr["errmsg"])
+		logger.Log.Errorw("调用微信生成小程序码错误", "err", err)
+		return nil, err
+	}
+	err = nil
+	// 解析为图片
+	qrImg, _, err = decodeImage(wxBody, "jpg")
+	if err != nil {
+		logger.Log.Errorw("小程序码返回body解析错误", "err", err)
+		return nil, err
 	}
 	return
 }
 
+// containsString 字符串列表中是否包含指定字符串
+func containsString(list []string, str string) bool {
+	for _, v := range list {
+		if v == str {
+			return true
+		}
+	}
+	return false
+}
+
+// 是否使用兼容旧版本的布局
+func (s *Service) legacyLayout() bool {
+	return s.Param.LayoutMode == LayoutModeLegacy
+}
+
+// hasLegacyObjects 是否使用了旧版本的文本、子图片、二维码、小程序码字段
+func (p *PosterParam) hasLegacyObjects() bool {
+	return len(p.Texts) > 0 || len(p.SubImages) > 0 || len(p.SubQrCode) > 0 || len(p.SubWxQrCode) > 0
+}
+
+// rotateImage 旋转图片 - 绘制到width*height的透明画布中心，bgColor不为nil时先填充背景色
+func rotateImage(src image.Image, width, height int, angle float64, bgColor color.Color) (image.Image, error) {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	if bgColor != nil {
+		draw.Draw(dst, dst.Bounds(), image.NewUniform(bgColor), image.ZP, draw.Src)
+	}
+	err := graphics.Rotate(dst, src, &graphics.RotateOptions{Angle: gg.Radians(angle)})
+	if err != nil {
+		return nil, err
+	}
+	return dst, nil
+}
+
+// drawOver 以Porter-Duff over方式合成图片，保留src的透明度，opacity为整体不透明度(0-1)
+func drawOver(dst draw.Image, r image.Rectangle, src image.Image, sp image.Point, opacity float64) {
+	if opacity < 1 {
+		mask := image.NewUniform(color.Alpha16{A: uint16(opacity * 0xffff)})
+		draw.DrawMask(dst, r, src, sp, mask, image.ZP, draw.Over)
+		return
+	}
+	draw.Draw(dst, r, src, sp, draw.Over)
+}
+
+// loadImage 获取并解析图片 - 根据图片内容识别格式，imageType为无法识别时使用的格式，可为空
+// 同一个图片在按多个倍数绘制时只获取和解析一次
+func (s *Service) loadImage(img []byte, imgUrl string, imageType string) (image.Image, error) {
+	return s.sources.get(newImageSourceKey(img, imgUrl, imageType), func() (image.Image, error) {
+		r, err := s.getBackgroundImg(img, imgUrl)
+		if err != nil {
+			return nil, err
+		}
+		decoded, _, err := decodeImage(r, imageType)
+		return decoded, err
+	})
+}
+
 // getBackgroundImg 获取背景图
 func (s *Service) getBackgroundImg(img []byte, imgUrl string) (r io.Reader, err error) {
 	if len(img) != 0 {
@@ -443,32 +805,3 @@ func (s *Service) getBackgroundImg(img []byte, imgUrl string) (r io.Reader, err
 	}
 	return
 }
-
-var (
-	allFonts = new(sync.Map)
-)
-
-// 获取字体
-func (s *Service) getFont(name string) (font *truetype.Font, err error) {
-	if val, ok := allFonts.Load(name); ok == true {
-		font, ok = val.(*truetype.Font)
-		if ok == true {
-			return
-		}
-	}
-	fontFile, err := os.Open(fmt.Sprintf("./resources/fonts/%s", name))
-	if err != nil {
-		return
-	}
-	fontBytes, err := ioutil.ReadAll(fontFile)
-	if err != nil {
-		return
-	}
-	font, err = freetype.ParseFont(fontBytes)
-	if err != nil {
-		return
-	}
-	// 存储字体
-	allFonts.Store(name, font)
-	return
-}
@@ -1,11 +1,26 @@
 package service
 
 import (
+	"bytes"
+	"encoding/base64"
 	"encoding/json"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/shiguanghuxian/poster/program/config"
 	"github.com/shiguanghuxian/poster/program/logger"
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
 )
 
 func TestMain(m *testing.M) {
@@ -24,6 +39,7 @@ func TestMain(m *testing.M) {
 }
 
 func TestDrawPoster(t *testing.T) {
+	loadTestFonts(t)
 	for i := 0; i < 100; i++ {
 		req := new(PosterParam)
 		json.Unmarshal([]byte(reqStr), req)
@@ -63,6 +79,93 @@ func BenchmarkDrawPoster(b *testing.B) {
 	}
 }
 
+func TestNewServiceLayers(t *testing.T) {
+	req := new(PosterParam)
+	err := json.Unmarshal([]byte(`{
+		"background": {"image_url": "http://127.0.0.1/background.jpg"},
+		"texts": [{"content": "旧版文本"}],
+		"sub_qr_code": [{"content": "旧版二维码"}],
+		"layers": [
+			{"z_index": 2, "qr_code": {"content": "顶层二维码"}},
+			{"type": "text", "text": {"content": "新版文本"}},
+			{"z_index": -1, "image": {"image_url": "http://127.0.0.1/sub.png"}}
+		]
+	}`), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewService(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := make([]string, 0)
+	for _, l := range s.layers {
+		paths = append(paths, l.path)
+	}
+	want := "layers[2] sub_qr_code[0] texts[0] layers[1] layers[0]"
+	if strings.Join(paths, " ") != want {
+		t.Fatalf("got %v, want %s", paths, want)
+	}
+
+	req.Layers = append(req.Layers, &Layer{Type: LayerTypeImage, Text: &Text{Content: "类型不匹配"}})
+	_, err = NewService(req)
+	if err == nil {
+		t.Fatal("expected layer type mismatch error")
+	}
+}
+
+// 未指定布局模式时，只要使用了旧版本字段就保持旧版本定位
+func TestDefaultLayoutMode(t *testing.T) {
+	cases := []struct {
+		param *PosterParam
+		want  int
+	}{
+		{&PosterParam{SubQrCode: []*QrCode{{Content: "旧版二维码"}}}, LayoutModeLegacy},
+		{&PosterParam{Layers: []*Layer{{QrCode: &QrCode{Content: "二维码"}}}}, LayoutModeExact},
+		{&PosterParam{
+			SubQrCode: []*QrCode{{Content: "旧版二维码"}},
+			Layers:    []*Layer{{QrCode: &QrCode{Content: "二维码"}}},
+		}, LayoutModeLegacy},
+		{&PosterParam{
+			Texts:  []*Text{{Content: "旧版文本"}},
+			Layers: []*Layer{{QrCode: &QrCode{Content: "二维码"}}},
+		}, LayoutModeLegacy},
+		{&PosterParam{
+			SubQrCode:  []*QrCode{{Content: "旧版二维码"}},
+			Layers:     []*Layer{{QrCode: &QrCode{Content: "二维码"}}},
+			LayoutMode: LayoutModeExact,
+		}, LayoutModeExact},
+	}
+	for i, c := range cases {
+		c.param.Background = &Background{ImageURL: "http://127.0.0.1/background.jpg"}
+		s, err := NewService(c.param)
+		if err != nil {
+			t.Fatalf("case %d: %v", i, err)
+		}
+		if s.Param.LayoutMode != c.want {
+			t.Fatalf("case %d: layout mode got %d, want %d", i, s.Param.LayoutMode, c.want)
+		}
+	}
+}
+
+func TestSubObjectOrigin(t *testing.T) {
+	so := &SubObject{Top: 100, Left: 200}
+	if pt := so.origin(40, 20, true); pt.X != 220 || pt.Y != 110 {
+		t.Fatalf("legacy origin got %v", pt)
+	}
+	if pt := so.origin(40, 20, false); pt.X != 200 || pt.Y != 100 {
+		t.Fatalf("top-left origin got %v", pt)
+	}
+	so.Anchor = AnchorCenter
+	if pt := so.origin(40, 20, true); pt.X != 180 || pt.Y != 90 {
+		t.Fatalf("center origin got %v", pt)
+	}
+	so.Anchor = AnchorBottomRight
+	if pt := so.origin(40, 20, false); pt.X != 160 || pt.Y != 80 {
+		t.Fatalf("bottom-right origin got %v", pt)
+	}
+}
+
 var (
 	reqStr = `{
 		"background": {
@@ -96,3 +199,551 @@ var (
 		]
 	}`
 )
+
+func TestImageClipShape(t *testing.T) {
+	img := new(Image)
+	if err := json.Unmarshal([]byte(`{"border_radius": 20}`), img); err != nil || len(img.BorderRadius) != 1 {
+		t.Fatalf("radius number got %v %v", img.BorderRadius, err)
+	}
+	if err := json.Unmarshal([]byte(`{"border_radius": [1, 2, 3, 4]}`), img); err != nil || img.BorderRadius.corners()[3] != 4 {
+		t.Fatalf("radius array got %v %v", img.BorderRadius, err)
+	}
+	if err := (Radius{1, 2}).check(); err == nil {
+		t.Fatal("radius with 2 values should be invalid")
+	}
+
+	src := image.NewUniform(color.RGBA{255, 0, 0, 255})
+	square := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(square, square.Bounds(), src, image.ZP, draw.Src)
+	img = &Image{Shape: ShapeCircle}
+	clipped, err := img.clipShape(square)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, a := clipped.At(2, 2).RGBA(); a != 0 {
+		t.Fatalf("circle corner alpha got %d", a)
+	}
+	if _, _, _, a := clipped.At(50, 50).RGBA(); a != 0xffff {
+		t.Fatalf("circle center alpha got %d", a)
+	}
+	// 矩形且没有边框时不处理
+	if clipped, _ = (&Image{}).clipShape(square); clipped != image.Image(square) {
+		t.Fatal("rect image should not be clipped")
+	}
+}
+
+func TestAlphaMask(t *testing.T) {
+	// 左半白色不透明，右半黑色半透明
+	src := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	src.SetNRGBA(0, 0, color.NRGBA{255, 255, 255, 255})
+	src.SetNRGBA(1, 0, color.NRGBA{0, 0, 0, 128})
+	r := image.Rect(10, 10, 14, 12)
+	mask := newAlphaMask(src, r, MaskModeAlpha, 1)
+	if mask.Bounds() != r || mask.AlphaAt(10, 10).A != 255 || mask.AlphaAt(13, 11).A != 128 {
+		t.Fatalf("alpha mask got %v %v %v", mask.Bounds(), mask.AlphaAt(10, 10), mask.AlphaAt(13, 11))
+	}
+	mask = newAlphaMask(src, r, MaskModeLuminance, 0.5)
+	if a := mask.AlphaAt(10, 10).A; a < 127 || a > 128 || mask.AlphaAt(13, 11).A != 0 {
+		t.Fatalf("luminance mask got %v %v", mask.AlphaAt(10, 10), mask.AlphaAt(13, 11))
+	}
+	if b := fitImage(src, 30, 10, FitCover, nil, nil).Bounds(); b.Dx() != 30 || b.Dy() != 10 {
+		t.Fatalf("cover image bounds got %v", b)
+	}
+}
+
+func TestLayerOpacity(t *testing.T) {
+	// 不传为不透明，0为完全透明
+	for _, c := range []struct {
+		opacity string
+		red     uint8
+		green   uint8
+	}{
+		{``, 255, 0},
+		{`, "opacity": 1`, 255, 0},
+		{`, "opacity": 0.5`, 255, 127},
+		{`, "opacity": 0`, 255, 255},
+	} {
+		req := new(PosterParam)
+		err := json.Unmarshal([]byte(`{"width": 10, "height": 10, "background": {"color": "#FFFFFF"}, "layers": [
+			{"shape": {"type": "rect", "width": 10, "height": 10, "fill_color": "#FF0000"`+c.opacity+`}}
+		]}`), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := NewService(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.rgba = image.NewRGBA(image.Rect(0, 0, 10, 10))
+		if err = s.drawBackground(); err != nil {
+			t.Fatal(err)
+		}
+		if err = s.drawLayer(s.layers[0]); err != nil {
+			t.Fatal(err)
+		}
+		if px := s.rgba.RGBAAt(5, 5); px.R != c.red || int(px.G) < int(c.green) || int(px.G) > int(c.green)+1 {
+			t.Fatalf("opacity%s got %v", c.opacity, px)
+		}
+	}
+	if _, err := NewService(&PosterParam{Layers: []*Layer{{Text: &Text{SubObject: SubObject{Opacity: new(float64)}, Content: "a"}}}}); err != nil {
+		t.Fatal(err)
+	}
+	opacity := 1.5
+	if _, err := NewService(&PosterParam{Layers: []*Layer{{Text: &Text{SubObject: SubObject{Opacity: &opacity}, Content: "a"}}}}); err == nil {
+		t.Fatal("opacity 1.5 should be invalid")
+	}
+}
+
+func TestCheckShape(t *testing.T) {
+	cases := []struct {
+		shape string
+		ok    bool
+	}{
+		{`{"type": "rect", "width": 10, "height": 10, "fill_color": "#FFFFFF"}`, true},
+		{`{"type": "rect", "width": 10, "fill_color": "#FFFFFF"}`, false},
+		{`{"type": "circle", "width": 10, "height": 10}`, false},
+		{`{"type": "line", "points": [{"x": 0, "y": 0}, {"x": 10, "y": 0}]}`, true},
+		{`{"type": "line", "points": [{"x": 0, "y": 0}]}`, false},
+		{`{"type": "polygon", "points": [{"x": 0, "y": 0}, {"x": 10, "y": 0}], "fill_color": "#FFFFFF"}`, false},
+		{`{"type": "polyline", "points": [{"x": 0, "y": 0}, {"x": 10, "y": 0}], "dash": [0, 0]}`, false},
+		{`{"type": "star", "width": 10, "height": 10, "fill_color": "#FFFFFF"}`, false},
+	}
+	for _, c := range cases {
+		shape := new(Shape)
+		if err := json.Unmarshal([]byte(c.shape), shape); err != nil {
+			t.Fatal(err)
+		}
+		if err := checkShape(shape); (err == nil) != c.ok {
+			t.Fatalf("%s: got %v", c.shape, err)
+		}
+	}
+	// 线段默认黑色、宽度1
+	shape := &Shape{Type: ShapeLine, Points: []*Point{{0, 0}, {10, 10}}}
+	if err := checkShape(shape); err != nil || shape.StrokeColor != "#000000" || shape.StrokeWidth != 1 {
+		t.Fatalf("line defaults got %q %v %v", shape.StrokeColor, shape.StrokeWidth, err)
+	}
+}
+
+func TestGradient(t *testing.T) {
+	g := &Gradient{Stops: []*ColorStop{{Color: "#FF0000"}, {Color: "#00FF00"}, {Color: "#0000FF"}}}
+	if err := checkGradient(g); err != nil {
+		t.Fatal(err)
+	}
+	if g.Type != GradientLinear || g.Stops[1].Offset != 0.5 || g.Stops[2].Offset != 1 {
+		t.Fatalf("gradient defaults got %v %v %v", g.Type, g.Stops[1].Offset, g.Stops[2].Offset)
+	}
+	// 从左到右
+	img := g.image(image.Rect(100, 0, 200, 10))
+	if r, _, b, _ := img.At(100, 5).RGBA(); r>>8 != 255 || b != 0 {
+		t.Fatalf("gradient start got %v", img.At(100, 5))
+	}
+	if r, _, b, _ := img.At(199, 5).RGBA(); r != 0 || b>>8 < 240 {
+		t.Fatalf("gradient end got %v", img.At(199, 5))
+	}
+	for _, bad := range []*Gradient{
+		{Stops: []*ColorStop{{Color: "#FF0000"}}},
+		{Type: "conic", Stops: []*ColorStop{{Color: "#FF0000"}, {Color: "#00FF00"}}},
+		{Stops: []*ColorStop{{Color: "#FF0000"}, {Offset: 2, Color: "#00FF00"}}},
+		{Stops: []*ColorStop{{Color: "#FF0000"}, {Color: "red"}}},
+	} {
+		if err := checkGradient(bad); err == nil {
+			t.Fatalf("gradient %+v should be invalid", bad)
+		}
+	}
+}
+
+func TestDrawBackgroundColor(t *testing.T) {
+	// 左半透明、右半红色的png
+	src := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	src.SetNRGBA(1, 0, color.NRGBA{255, 0, 0, 255})
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, src); err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewService(&PosterParam{Width: 20, Height: 10, Background: &Background{Image: buf.Bytes(), ImageType: "png", Color: "#0000FF"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.rgba = image.NewRGBA(image.Rect(0, 0, 20, 10))
+	if err = s.drawBackground(); err != nil {
+		t.Fatal(err)
+	}
+	if c := s.rgba.RGBAAt(2, 5); c.B != 255 || c.R != 0 {
+		t.Fatalf("transparent part got %v", c)
+	}
+	if c := s.rgba.RGBAAt(18, 5); c.R != 255 || c.B != 0 {
+		t.Fatalf("image part got %v", c)
+	}
+	// 不传背景时为白色，背景没有任何内容时报错
+	if s, err = NewService(&PosterParam{}); err != nil || s.Param.Background.Color != "#FFFFFF" {
+		t.Fatalf("default background got %v", err)
+	}
+	if _, err = NewService(&PosterParam{Background: &Background{}}); err == nil {
+		t.Fatal("empty background should be invalid")
+	}
+}
+
+func TestFitImage(t *testing.T) {
+	// 左半红色、右半绿色的4*2图片
+	src := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	draw.Draw(src, image.Rect(0, 0, 2, 2), image.NewUniform(color.RGBA{255, 0, 0, 255}), image.ZP, draw.Src)
+	draw.Draw(src, image.Rect(2, 0, 4, 2), image.NewUniform(color.RGBA{0, 255, 0, 255}), image.ZP, draw.Src)
+	blue := color.RGBA{0, 0, 255, 255}
+
+	cases := []struct {
+		fit        string
+		focalPoint *Point
+		w, h       int
+		x, y       int
+		want       color.RGBA
+	}{
+		{FitCover, &Point{X: 0, Y: 0.5}, 20, 20, 12, 10, color.RGBA{255, 0, 0, 255}},
+		{FitCover, &Point{X: 1, Y: 0.5}, 20, 20, 8, 10, color.RGBA{0, 255, 0, 255}},
+		{FitContain, nil, 20, 20, 10, 1, blue},
+		{FitContain, nil, 20, 20, 2, 10, color.RGBA{255, 0, 0, 255}},
+		{FitCenter, nil, 20, 20, 0, 0, blue},
+		{FitCenter, nil, 20, 20, 9, 9, color.RGBA{255, 0, 0, 255}},
+		{FitTile, nil, 20, 20, 5, 5, color.RGBA{255, 0, 0, 255}},
+		{FitTile, nil, 20, 20, 7, 5, color.RGBA{0, 255, 0, 255}},
+	}
+	for _, c := range cases {
+		dst := fitImage(src, c.w, c.h, c.fit, c.focalPoint, blue)
+		if b := dst.Bounds(); b.Dx() != c.w || b.Dy() != c.h {
+			t.Fatalf("%s size got %v", c.fit, b)
+		}
+		r, g, b, _ := dst.At(c.x, c.y).RGBA()
+		if got := (color.RGBA{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), 255}); got != c.want {
+			t.Fatalf("%s at (%d,%d) got %v want %v", c.fit, c.x, c.y, got, c.want)
+		}
+	}
+
+	fit := ""
+	if err := checkFit(&fit, nil); err != nil || fit != FitStretch {
+		t.Fatalf("default fit got %q %v", fit, err)
+	}
+	fit = "fill"
+	if err := checkFit(&fit, nil); err == nil {
+		t.Fatal("unknown fit should be invalid")
+	}
+	fit = FitCover
+	if err := checkFit(&fit, &Point{X: 1.5}); err == nil {
+		t.Fatal("focal point out of range should be invalid")
+	}
+}
+
+func TestDecodeImage(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	draw.Draw(src, src.Bounds(), image.NewUniform(color.RGBA{255, 0, 0, 255}), image.ZP, draw.Src)
+	encoders := map[string]func(io.Writer, image.Image) error{
+		"png":  png.Encode,
+		"bmp":  bmp.Encode,
+		"gif":  func(w io.Writer, m image.Image) error { return gif.Encode(w, m, nil) },
+		"tiff": func(w io.Writer, m image.Image) error { return tiff.Encode(w, m, nil) },
+	}
+	for name, encode := range encoders {
+		buf := new(bytes.Buffer)
+		if err := encode(buf, src); err != nil {
+			t.Fatal(err)
+		}
+		// 不传格式或传错格式都根据内容识别
+		for _, hint := range []string{"", "jpg"} {
+			img, format, err := decodeImage(bytes.NewReader(buf.Bytes()), hint)
+			if err != nil || format != name || img.Bounds().Dx() != 3 || img.Bounds().Dy() != 2 {
+				t.Fatalf("%s hint %q got %v %v", name, hint, format, err)
+			}
+			if r, _, _, _ := img.At(1, 1).RGBA(); r>>8 != 255 {
+				t.Fatalf("%s got %v", name, img.At(1, 1))
+			}
+		}
+	}
+	// 1x1的无损webp
+	webpImg, _ := base64.StdEncoding.DecodeString("UklGRhoAAABXRUJQVlA4TA0AAAAvAAAAEAcQERGIiP4HAA==")
+	if img, format, err := decodeImage(bytes.NewReader(webpImg), ""); err != nil || format != "webp" || img.Bounds().Dx() != 1 {
+		t.Fatalf("webp got %v %v", format, err)
+	}
+	if _, _, err := decodeImage(strings.NewReader("not an image"), ""); err == nil {
+		t.Fatal("unknown format should fail")
+	}
+
+	imageType := "PNG"
+	if err := checkImageType(&imageType); err != nil || imageType != "png" {
+		t.Fatalf("image type got %q %v", imageType, err)
+	}
+	imageType = "psd"
+	if err := checkImageType(&imageType); err == nil {
+		t.Fatal("unsupported image type should be invalid")
+	}
+}
+
+func TestImageOrientation(t *testing.T) {
+	// 3*2图片，左上角为红色
+	red := color.RGBA{255, 0, 0, 255}
+	src := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	draw.Draw(src, src.Bounds(), image.NewUniform(color.White), image.ZP, draw.Src)
+	src.SetRGBA(0, 0, red)
+	cases := []struct {
+		orientation int
+		w, h        int
+		x, y        int
+	}{
+		{1, 3, 2, 0, 0},
+		{2, 3, 2, 2, 0},
+		{3, 3, 2, 2, 1},
+		{4, 3, 2, 0, 1},
+		{5, 2, 3, 0, 0},
+		{6, 2, 3, 1, 0},
+		{7, 2, 3, 1, 2},
+		{8, 2, 3, 0, 2},
+	}
+	for _, c := range cases {
+		img := applyOrientation(src, c.orientation)
+		if b := img.Bounds(); b.Dx() != c.w || b.Dy() != c.h {
+			t.Fatalf("orientation %d size got %v", c.orientation, b)
+		}
+		if r, g, _, _ := img.At(c.x, c.y).RGBA(); r>>8 != 255 || g != 0 {
+			t.Fatalf("orientation %d red pixel not at (%d,%d)", c.orientation, c.x, c.y)
+		}
+	}
+
+	// 带EXIF方向6(需顺时针旋转90度)的jpg，左上角16*16为红色
+	photo := image.NewRGBA(image.Rect(0, 0, 64, 32))
+	draw.Draw(photo, photo.Bounds(), image.NewUniform(color.White), image.ZP, draw.Src)
+	draw.Draw(photo, image.Rect(0, 0, 16, 16), image.NewUniform(red), image.ZP, draw.Src)
+	buf := new(bytes.Buffer)
+	if err := jpeg.Encode(buf, photo, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	// APP1段: Exif头 + 小端TIFF头 + IFD0中一个Orientation(0x0112)标签
+	exifData := []byte{
+		'E', 'x', 'i', 'f', 0, 0,
+		'I', 'I', 0x2a, 0, 8, 0, 0, 0,
+		1, 0,
+		0x12, 0x01, 3, 0, 1, 0, 0, 0, 6, 0, 0, 0,
+		0, 0, 0, 0,
+	}
+	jpg := append([]byte{0xff, 0xd8, 0xff, 0xe1, 0, byte(len(exifData) + 2)}, exifData...)
+	jpg = append(jpg, buf.Bytes()[2:]...)
+	img, _, err := decodeImage(bytes.NewReader(jpg), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 32 || b.Dy() != 64 {
+		t.Fatalf("rotated size got %v", b)
+	}
+	if r, g, _, _ := img.At(24, 8).RGBA(); r>>8 < 200 || g>>8 > 60 {
+		t.Fatalf("red corner got %v", img.At(24, 8))
+	}
+}
+
+func TestOutput(t *testing.T) {
+	cases := []struct {
+		output *Output
+		mime   string
+		format string
+	}{
+		{nil, "image/jpeg", "jpeg"},
+		{&Output{Format: "jpg", Quality: 90, Progressive: true}, "image/jpeg", "jpeg"},
+		{&Output{Format: OutputFormatPNG, CompressionLevel: 9}, "image/png", "png"},
+		{&Output{Format: OutputFormatWebP}, "image/webp", "webp"},
+	}
+	for _, c := range cases {
+		s, err := NewService(&PosterParam{Width: 40, Height: 20, Output: c.output})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := s.Param.Output.MimeType(); got != c.mime {
+			t.Fatalf("%s mime got %s", c.format, got)
+		}
+		data, err := s.DrawPoster()
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, format, err := decodeImage(bytes.NewReader(data), "")
+		if err != nil || format != c.format {
+			t.Fatalf("%s output got %s %v", c.format, format, err)
+		}
+		// 不传背景时jpg为白色，png和webp为透明
+		_, _, _, a := img.At(10, 10).RGBA()
+		if s.Param.Output.transparent() == (a != 0) {
+			t.Fatalf("%s background alpha got %d", c.format, a)
+		}
+	}
+	for _, o := range []*Output{{Format: "gif"}, {Quality: 101}, {Format: OutputFormatWebP, Quality: 80}, {CompressionLevel: 10}} {
+		if _, err := NewService(&PosterParam{Output: o}); err == nil {
+			t.Fatalf("output %+v should be invalid", o)
+		}
+	}
+}
+
+func TestScale(t *testing.T) {
+	loadTestFonts(t)
+	// 图片地址只下载一次
+	var pngBody bytes.Buffer
+	png.Encode(&pngBody, image.NewRGBA(image.Rect(0, 0, 4, 4)))
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write(pngBody.Bytes())
+	}))
+	defer srv.Close()
+	newParam := func() *PosterParam {
+		return &PosterParam{
+			Width:  40,
+			Height: 30,
+			Output: &Output{Format: OutputFormatPNG},
+			Layers: []*Layer{
+				{Shape: &Shape{SubObject: SubObject{Top: 10, Left: 10, Width: 20, Height: 10}, Type: ShapeRect, FillColor: "#FF0000"}},
+				{Text: &Text{SubObject: SubObject{Width: 40}, Content: "A", FontSize: 10, Stroke: &TextStroke{}}},
+				{Image: &Image{SubObject: SubObject{Left: 30, Width: 10, Height: 5}, ImageURL: srv.URL}},
+			},
+		}
+	}
+	param := newParam()
+	param.Scales = []float64{1, 2, 3}
+	s, err := NewService(param)
+	if err != nil {
+		t.Fatal(err)
+	}
+	images, err := s.DrawPosters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 3 || requests != 1 {
+		t.Fatalf("images got %d, image requests %d", len(images), requests)
+	}
+	for _, v := range images {
+		img, _, err := decodeImage(bytes.NewReader(v.Image), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		w, h := int(40*v.Scale), int(30*v.Scale)
+		if img.Bounds().Dx() != w || img.Bounds().Dy() != h || v.Width != w || v.Height != h {
+			t.Fatalf("scale %v size got %v", v.Scale, img.Bounds())
+		}
+		// 逻辑坐标(20, 15)在矩形内，(5, 25)在矩形外
+		if r, g, _, _ := img.At(int(20*v.Scale), int(15*v.Scale)).RGBA(); r>>8 != 255 || g != 0 {
+			t.Fatalf("scale %v rect got %v", v.Scale, img.At(int(20*v.Scale), int(15*v.Scale)))
+		}
+		if _, _, _, a := img.At(int(5*v.Scale), int(25*v.Scale)).RGBA(); a != 0 {
+			t.Fatalf("scale %v outside rect got alpha %d", v.Scale, a)
+		}
+	}
+	// 缩放不修改原参数，默认值也按倍数缩放
+	txt := param.Layers[1].Text
+	if txt.FontSize != 10 || txt.Stroke.Width != 2 {
+		t.Fatalf("original text changed: font size %v stroke %v", txt.FontSize, txt.Stroke.Width)
+	}
+	scaled := s.scaled(3).layers[1].Text
+	if scaled.FontSize != 30 || scaled.Stroke.Width != 6 || scaled.MinFontSize != 30 {
+		t.Fatalf("scaled text got font size %v stroke %v min %v", scaled.FontSize, scaled.Stroke.Width, scaled.MinFontSize)
+	}
+
+	param = newParam()
+	param.Scale = 2
+	if s, err = NewService(param); err != nil {
+		t.Fatal(err)
+	}
+	data, err := s.DrawPoster()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img, _, err := decodeImage(bytes.NewReader(data), ""); err != nil || img.Bounds().Dx() != 80 {
+		t.Fatalf("scale 2 got %v", err)
+	}
+	for _, scale := range []float64{-1, 5} {
+		param = newParam()
+		param.Scale = scale
+		if _, err = NewService(param); err == nil {
+			t.Fatalf("scale %v should be invalid", scale)
+		}
+	}
+}
+
+// newStrokeParam 一行描边文字，未设置宽高，文字区域到画布边缘
+func newStrokeParam() *PosterParam {
+	return &PosterParam{
+		Scale: 3,
+		Layers: []*Layer{
+			{Text: &Text{SubObject: SubObject{Top: 100, Left: 40}, Content: "Hello world", FontSize: 40, Stroke: &TextStroke{Width: 10}, Shadow: &TextShadow{OffsetX: 4, OffsetY: 4, Blur: 20}}},
+		},
+	}
+}
+
+func TestTextStrokeScale(t *testing.T) {
+	loadTestFonts(t)
+	s, err := NewService(newStrokeParam())
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if _, err = s.DrawPoster(); err != nil {
+		t.Fatal(err)
+	}
+	// 描边和阴影只处理文字所在区域，3倍时也应在1秒左右完成
+	if cost := time.Since(start); cost > 5*time.Second {
+		t.Fatalf("stroke at scale 3 took %v", cost)
+	}
+	// 放大后不超过最大值
+	for _, v := range []*Text{{Stroke: &TextStroke{Width: MaxTextStrokeWidth + 1}}, {Shadow: &TextShadow{Blur: MaxTextShadowBlur + 1}}} {
+		v.Content = "a"
+		if _, err = NewService(&PosterParam{Layers: []*Layer{{Text: v}}}); err == nil {
+			t.Fatal("stroke and shadow over the maximum should be invalid")
+		}
+	}
+	txt := &Text{Stroke: &TextStroke{Width: 20}, Shadow: &TextShadow{Blur: 40}}
+	if scaled := txt.scaled(MaxScale); scaled.Stroke.Width != MaxTextStrokeWidth || scaled.Shadow.Blur != MaxTextShadowBlur {
+		t.Fatalf("scaled stroke %v blur %d", scaled.Stroke.Width, scaled.Shadow.Blur)
+	}
+}
+
+func BenchmarkTextStrokeScale(b *testing.B) {
+	loadTestFonts(b)
+	for i := 0; i < b.N; i++ {
+		s, err := NewService(newStrokeParam())
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err = s.DrawPoster(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestWxQrCodeSource(t *testing.T) {
+	// 记录请求的宽度，返回对应宽度的图片
+	var widths []int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := struct {
+			Width int `json:"width"`
+		}{}
+		json.NewDecoder(r.Body).Decode(&req)
+		widths = append(widths, req.Width)
+		png.Encode(w, image.NewRGBA(image.Rect(0, 0, req.Width, req.Width)))
+	}))
+	defer srv.Close()
+	api := wxQrCodeAPI
+	wxQrCodeAPI = srv.URL
+	defer func() {
+		wxQrCodeAPI = api
+	}()
+
+	// 相同scene、page不同宽度的两个小程序码分别请求，每个图层的多个倍数只请求一次
+	s, err := NewService(&PosterParam{
+		Width:  100,
+		Height: 100,
+		Scales: []float64{1, 2},
+		Layers: []*Layer{
+			{WxQrCode: &WxQrCode{SubObject: SubObject{Width: 20}, AccessToken: "token", Scene: "a"}},
+			{WxQrCode: &WxQrCode{SubObject: SubObject{Top: 50, Width: 40}, AccessToken: "token", Scene: "a"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.DrawPosters(); err != nil {
+		t.Fatal(err)
+	}
+	if len(widths) != 2 || widths[0] != 40 || widths[1] != 80 {
+		t.Fatalf("requested widths got %v", widths)
+	}
+}
@@ -0,0 +1,268 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+
+	"github.com/fogleman/gg"
+	"github.com/shiguanghuxian/poster/program/common"
+	"github.com/shiguanghuxian/poster/program/logger"
+)
+
+// 矢量图形，以及图片的圆角、圆形、椭圆裁剪和边框
+
+// Radius 圆角半径 - 可以是一个数字，或按左上、右上、右下、左下顺序的4个数字
+type Radius []float64
+
+// UnmarshalJSON 同时支持数字和数组
+func (r *Radius) UnmarshalJSON(b []byte) error {
+	var v float64
+	if err := json.Unmarshal(b, &v); err == nil {
+		*r = Radius{v}
+		return nil
+	}
+	var list []float64
+	if err := json.Unmarshal(b, &list); err != nil {
+		return errors.New("The border radius must be a number or an array of 4 numbers")
+	}
+	*r = list
+	return nil
+}
+
+// check 检查圆角半径
+func (r Radius) check() error {
+	if len(r) != 0 && len(r) != 1 && len(r) != 4 {
+		return errors.New("The border radius must be a number or an array of 4 numbers")
+	}
+	for _, v := range r {
+		if v < 0 {
+			return errors.New("The border radius cannot be negative")
+		}
+	}
+	return nil
+}
+
+// corners 四个角的半径 - 左上、右上、右下、左下
+func (r Radius) corners() (corners [4]float64) {
+	switch len(r) {
+	case 1:
+		corners = [4]float64{r[0], r[0], r[0], r[0]}
+	case 4:
+		copy(corners[:], r)
+	}
+	return
+}
+
+// roundedRectPath 圆角矩形路径，半径超过短边一半时按短边一半处理
+func roundedRectPath(dc *gg.Context, x, y, w, h float64, corners [4]float64) {
+	max := math.Max(math.Min(w, h)/2, 0)
+	for i := range corners {
+		corners[i] = math.Max(math.Min(corners[i], max), 0)
+	}
+	tl, tr, br, bl := corners[0], corners[1], corners[2], corners[3]
+	dc.NewSubPath()
+	dc.MoveTo(x+tl, y)
+	dc.LineTo(x+w-tr, y)
+	if tr > 0 {
+		dc.DrawArc(x+w-tr, y+tr, tr, -math.Pi/2, 0)
+	}
+	dc.LineTo(x+w, y+h-br)
+	if br > 0 {
+		dc.DrawArc(x+w-br, y+h-br, br, 0, math.Pi/2)
+	}
+	dc.LineTo(x+bl, y+h)
+	if bl > 0 {
+		dc.DrawArc(x+bl, y+h-bl, bl, math.Pi/2, math.Pi)
+	}
+	dc.LineTo(x, y+tl)
+	if tl > 0 {
+		dc.DrawArc(x+tl, y+tl, tl, math.Pi, math.Pi*3/2)
+	}
+	dc.ClosePath()
+}
+
+// shapePath 图片形状路径，inset为向内收缩的距离
+func (subImg *Image) shapePath(dc *gg.Context, w, h, inset float64) {
+	switch subImg.Shape {
+	case ShapeCircle:
+		dc.DrawCircle(w/2, h/2, math.Max(math.Min(w, h)/2-inset, 0))
+	case ShapeEllipse:
+		dc.DrawEllipse(w/2, h/2, math.Max(w/2-inset, 0), math.Max(h/2-inset, 0))
+	default:
+		corners := subImg.BorderRadius.corners()
+		for i := range corners {
+			corners[i] = math.Max(corners[i]-inset, 0)
+		}
+		roundedRectPath(dc, inset, inset, w-inset*2, h-inset*2, corners)
+	}
+}
+
+// clipShape 按形状裁剪图片并绘制边框，矩形且没有边框时返回原图
+func (subImg *Image) clipShape(src image.Image) (image.Image, error) {
+	rounded := false
+	for _, v := range subImg.BorderRadius {
+		rounded = rounded || v > 0
+	}
+	if (subImg.Shape == "" || subImg.Shape == ShapeRect) && rounded == false && subImg.Border == nil {
+		return src, nil
+	}
+	b := src.Bounds()
+	w, h := float64(b.Dx()), float64(b.Dy())
+	// 抗锯齿的形状蒙版
+	mask := gg.NewContext(b.Dx(), b.Dy())
+	subImg.shapePath(mask, w, h, 0)
+	mask.Fill()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.DrawMask(dst, dst.Bounds(), src, b.Min, mask.Image(), image.ZP, draw.Src)
+	// 边框 - 沿形状内侧绘制
+	if subImg.Border != nil && subImg.Border.Width > 0 {
+		borderColor, err := common.HexToColor(subImg.Border.Color)
+		if err != nil {
+			return nil, err
+		}
+		dc := gg.NewContextForRGBA(dst)
+		subImg.shapePath(dc, w, h, subImg.Border.Width/2)
+		dc.SetColor(borderColor)
+		dc.SetLineWidth(subImg.Border.Width)
+		dc.Stroke()
+	}
+	return dst, nil
+}
+
+// 检查图形参数并设置默认值
+func checkShape(shape *Shape) (err error) {
+	if err = checkSubObject(&shape.SubObject); err != nil {
+		return
+	}
+	minPoints := 0
+	switch shape.Type {
+	case ShapeRect, ShapeRoundedRect, ShapeCircle, ShapeEllipse:
+		if shape.Width <= 0 || shape.Height <= 0 {
+			return errors.New("The shape width and height must be greater than 0")
+		}
+	case ShapeLine, ShapePolyline:
+		minPoints = 2
+		if shape.StrokeColor == "" {
+			shape.StrokeColor = "#000000"
+		}
+	case ShapePolygon:
+		minPoints = 3
+	default:
+		return fmt.Errorf("Unsupported shape type -- %s", shape.Type)
+	}
+	if shape.Type == ShapeLine && len(shape.Points) != 2 {
+		return errors.New("The shape line requires 2 points")
+	}
+	if len(shape.Points) < minPoints {
+		return fmt.Errorf("The shape %s requires at least %d points", shape.Type, minPoints)
+	}
+	for _, p := range shape.Points {
+		if p == nil {
+			return errors.New("The shape point cannot be empty")
+		}
+	}
+	if err = shape.Radius.check(); err != nil {
+		return
+	}
+	if shape.Gradient != nil {
+		if err = checkGradient(shape.Gradient); err != nil {
+			return
+		}
+	}
+	if shape.FillColor == "" && shape.Gradient == nil && shape.StrokeColor == "" {
+		return errors.New("The shape requires a fill color or a stroke color")
+	}
+	if shape.StrokeWidth < 0 {
+		return errors.New("The stroke width cannot be negative")
+	}
+	if shape.StrokeWidth == 0 {
+		shape.StrokeWidth = 1
+	}
+	dashLength := 0.0
+	for _, v := range shape.Dash {
+		if v < 0 {
+			return errors.New("The dash lengths cannot be negative")
+		}
+		dashLength += v
+	}
+	if len(shape.Dash) > 0 && dashLength == 0 {
+		return errors.New("The dash lengths cannot be all zero")
+	}
+	return checkColors("fill color", shape.FillColor, "stroke color", shape.StrokeColor)
+}
+
+// path 图形路径，x、y为区域左上角
+func (shape *Shape) path(dc *gg.Context, x, y float64) {
+	w, h := float64(shape.Width), float64(shape.Height)
+	switch shape.Type {
+	case ShapeRect:
+		dc.DrawRectangle(x, y, w, h)
+	case ShapeRoundedRect:
+		roundedRectPath(dc, x, y, w, h, shape.Radius.corners())
+	case ShapeCircle:
+		dc.DrawCircle(x+w/2, y+h/2, math.Min(w, h)/2)
+	case ShapeEllipse:
+		dc.DrawEllipse(x+w/2, y+h/2, w/2, h/2)
+	default:
+		dc.NewSubPath()
+		for _, p := range shape.Points {
+			dc.LineTo(x+p.X, y+p.Y)
+		}
+		if shape.Type == ShapePolygon {
+			dc.ClosePath()
+		}
+	}
+}
+
+// 绘制矢量图形
+func (s *Service) drawShape(dst *image.RGBA, k string, shape *Shape) (err error) {
+	box := shape.bounds(shape.Width, shape.Height, false)
+	dc := gg.NewContextForRGBA(dst)
+	shape.path(dc, float64(box.Min.X), float64(box.Min.Y))
+	// 线段图形不填充
+	closed := shape.Type != ShapeLine && shape.Type != ShapePolyline
+	if shape.Gradient != nil && closed == true {
+		dc.SetFillStyle(shape.Gradient.pattern(shape.fillBounds(box)))
+		dc.FillPreserve()
+	} else if shape.FillColor != "" && closed == true {
+		fillColor, err := common.HexToColor(shape.FillColor)
+		if err != nil {
+			logger.Log.Errorw("解析图形填充色错误", "err", err, "subKey", k, "FillColor", shape.FillColor)
+			return err
+		}
+		dc.SetColor(fillColor)
+		dc.FillPreserve()
+	}
+	if shape.StrokeColor != "" {
+		strokeColor, err := common.HexToColor(shape.StrokeColor)
+		if err != nil {
+			logger.Log.Errorw("解析图形线条颜色错误", "err", err, "subKey", k, "StrokeColor", shape.StrokeColor)
+			return err
+		}
+		dc.SetColor(strokeColor)
+		dc.SetLineWidth(shape.StrokeWidth)
+		dc.SetDash(shape.Dash...)
+		dc.StrokePreserve()
+	}
+	dc.ClearPath()
+	return
+}
+
+// fillBounds 渐变填充区域 - 未设置宽高时为所有点的范围
+func (shape *Shape) fillBounds(box image.Rectangle) image.Rectangle {
+	if shape.Width > 0 && shape.Height > 0 {
+		return box
+	}
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, p := range shape.Points {
+		minX, minY = math.Min(minX, p.X), math.Min(minY, p.Y)
+		maxX, maxY = math.Max(maxX, p.X), math.Max(maxY, p.Y)
+	}
+	r := image.Rect(int(math.Floor(minX)), int(math.Floor(minY)), int(math.Ceil(maxX)), int(math.Ceil(maxY)))
+	return r.Add(box.Min)
+}
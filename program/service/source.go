@@ -0,0 +1,55 @@
+package service
+
+import (
+	"image"
+)
+
+// 图片来源缓存 - 按多个倍数绘制时共用，图片地址只下载一次，小程序码只请求一次，每个倍数只重新绘制
+
+// imageSourceKey 图片来源 - base64图片按切片地址区分，缩放后的参数和原参数共用同一个切片
+type imageSourceKey struct {
+	data      *byte
+	size      int
+	url       string
+	imageType string
+}
+
+// wxQrCodeSourceKey 小程序码来源 - 按图层区分，同一图层的各个倍数共用，按最大倍数的宽度请求后缩小
+type wxQrCodeSourceKey struct {
+	layer string
+}
+
+// sourceCache 解析后的图片
+type sourceCache struct {
+	images map[interface{}]image.Image
+}
+
+// newSourceCache 创建图片来源缓存
+func newSourceCache() *sourceCache {
+	return &sourceCache{images: make(map[interface{}]image.Image)}
+}
+
+// get 获取图片，没有时调用load并缓存 - 获取失败时不缓存，未创建缓存时每次都调用load
+func (c *sourceCache) get(key interface{}, load func() (image.Image, error)) (image.Image, error) {
+	if c == nil {
+		return load()
+	}
+	if img, ok := c.images[key]; ok == true {
+		return img, nil
+	}
+	img, err := load()
+	if err != nil {
+		return nil, err
+	}
+	c.images[key] = img
+	return img, nil
+}
+
+// newImageSourceKey 图片来源
+func newImageSourceKey(img []byte, imgUrl string, imageType string) imageSourceKey {
+	key := imageSourceKey{size: len(img), url: imgUrl, imageType: imageType}
+	if len(img) > 0 {
+		key.data = &img[0]
+	}
+	return key
+}
@@ -0,0 +1,648 @@
+package service
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strings"
+	"unicode"
+
+	"github.com/golang/freetype/truetype"
+	"github.com/shiguanghuxian/poster/program/common"
+	"github.com/shiguanghuxian/poster/program/logger"
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// 文字排版 - 按字形实际宽度换行，多个不同样式的片段一起换行和对齐
+
+// textStyle 文字片段的样式
+type textStyle struct {
+	face          font.Face        // 主字体
+	fonts         []*truetype.Font // 字体及备用字体 - 依次查找包含字符的字体
+	faces         []font.Face      // fonts对应的指定字号字体
+	emoji         *emojiSet        // 表情图片集
+	size          float64          // 字号
+	color         color.Color
+	bold          bool          // 加粗
+	underline     bool          // 下划线
+	strikethrough bool          // 删除线
+	letterSpacing fixed.Int26_6 // 字间距
+}
+
+// boldOffset 加粗时字形重复绘制的水平偏移
+func (st *textStyle) boldOffset() fixed.Int26_6 {
+	if st.bold == false {
+		return 0
+	}
+	return fixed.Int26_6(math.Max(st.size/24, 1) * 64)
+}
+
+// faceFor 包含字符的第一个字体，都不包含时使用主字体
+func (st *textStyle) faceFor(r rune) font.Face {
+	for i, f := range st.fonts {
+		if f.Index(r) != 0 {
+			return st.faces[i]
+		}
+	}
+	return st.face
+}
+
+// hasGlyph 字体及备用字体中是否包含字符
+func (st *textStyle) hasGlyph(r rune) bool {
+	for _, f := range st.fonts {
+		if f.Index(r) != 0 {
+			return true
+		}
+	}
+	return len(st.fonts) == 0
+}
+
+// glyph 排版后的单个字符
+type glyph struct {
+	r     rune
+	adv   fixed.Int26_6 // 字符宽度 - 包含字间距
+	kern  fixed.Int26_6 // 与前一个字符的字距调整
+	style *textStyle
+	face  font.Face // 绘制所用字体
+	emoji string    // 表情图片路径 - 不为空时绘制图片
+	text  string    // 表情序列包含多个字符时的完整内容
+}
+
+// textLine 换行后的一行文字
+type textLine struct {
+	glyphs  []glyph
+	width   fixed.Int26_6 // 行宽
+	last    bool          // 是否为段落最后一行 - 两端对齐时不拉伸
+	size    float64       // 行内最大字号
+	ascent  fixed.Int26_6 // 基线以上高度
+	descent fixed.Int26_6 // 基线以下高度
+}
+
+// String 行内文字
+func (l *textLine) String() string {
+	var b strings.Builder
+	for _, g := range l.glyphs {
+		if g.text != "" {
+			b.WriteString(g.text)
+		} else {
+			b.WriteRune(g.r)
+		}
+	}
+	return b.String()
+}
+
+// push 追加字符 - 行首字符不计算与前一个字符的字距
+func (l *textLine) push(gs ...glyph) {
+	for _, g := range gs {
+		if len(l.glyphs) == 0 {
+			g.kern = 0
+		}
+		l.glyphs = append(l.glyphs, g)
+		l.width += g.kern + g.adv
+	}
+}
+
+// 去掉行尾空白
+func (l *textLine) trimRight() {
+	for len(l.glyphs) > 0 && unicode.IsSpace(l.glyphs[len(l.glyphs)-1].r) {
+		g := l.glyphs[len(l.glyphs)-1]
+		l.glyphs = l.glyphs[:len(l.glyphs)-1]
+		l.width -= g.kern + g.adv
+	}
+}
+
+// measure 计算行内最大字号和基线上下高度，空行使用文本默认样式
+func (l *textLine) measure(base *textStyle) {
+	styles := []*textStyle{base}
+	if len(l.glyphs) > 0 {
+		styles = styles[:0]
+		for _, g := range l.glyphs {
+			styles = append(styles, g.style)
+		}
+	}
+	l.size, l.ascent, l.descent = 0, 0, 0
+	for _, st := range styles {
+		metrics := st.face.Metrics()
+		l.size = math.Max(l.size, st.size)
+		if metrics.Ascent > l.ascent {
+			l.ascent = metrics.Ascent
+		}
+		if metrics.Descent > l.descent {
+			l.descent = metrics.Descent
+		}
+	}
+}
+
+// newFace 创建指定字号的字体 - 与freetype.Context默认参数一致，72dpi且不使用hinting
+func newFace(f *truetype.Font, size float64) font.Face {
+	return truetype.NewFace(f, &truetype.Options{
+		Size:    size,
+		DPI:     72,
+		Hinting: font.HintingNone,
+	})
+}
+
+// textSpans 文本片段 - 未设置Spans时Content为唯一的片段
+func (txt *Text) textSpans() []*TextSpan {
+	if len(txt.Spans) > 0 {
+		return txt.Spans
+	}
+	return []*TextSpan{{Content: txt.Content}}
+}
+
+// textStyles 创建文本默认样式和每个片段的样式，片段未设置的属性继承所在文本
+// scale为shrink时的字号缩放比例
+func (s *Service) textStyles(txt *Text, scale float64) (base *textStyle, styles []*textStyle, err error) {
+	emoji := getEmojiSet(fontConfig().EmojiDir)
+	faces := make(map[string]font.Face)
+	newStyle := func(fontName string, size float64, fontColor string, bold bool) (*textStyle, error) {
+		c, err := common.HexToColor(fontColor)
+		if err != nil {
+			return nil, err
+		}
+		st := &textStyle{emoji: emoji, size: size, color: c, bold: bold}
+		// 加粗时优先使用同一家族的粗体字体，没有时加粗绘制
+		if bold == true {
+			if boldName := registry().boldName(fontName); boldName != "" {
+				fontName = boldName
+				st.bold = false
+			}
+		}
+		// 字体、文本备用字体、全局备用字体
+		names := append([]string{fontName}, txt.FallbackFonts...)
+		names = append(names, fontConfig().Fallbacks...)
+		added := make(map[string]bool)
+		for i, name := range names {
+			if added[name] == true {
+				continue
+			}
+			added[name] = true
+			f, err := s.getFont(name)
+			if err != nil {
+				// 备用字体不存在时跳过
+				if i > 0 {
+					logger.Log.Warnw("获取备用字体错误", "err", err, "font", name)
+					continue
+				}
+				return nil, err
+			}
+			key := fmt.Sprintf("%s:%v", name, size)
+			face, ok := faces[key]
+			if ok == false {
+				face = newFace(f, size)
+				faces[key] = face
+			}
+			st.fonts = append(st.fonts, f)
+			st.faces = append(st.faces, face)
+		}
+		st.face = st.faces[0]
+		return st, nil
+	}
+
+	base, err = newStyle(txt.FontName, txt.FontSize*scale, txt.FontColor, txt.Bold)
+	if err != nil {
+		return
+	}
+	base.underline = txt.Underline
+	base.strikethrough = txt.Strikethrough
+	base.letterSpacing = fixed.Int26_6(txt.LetterSpacing * scale * 64)
+
+	for _, span := range txt.textSpans() {
+		fontName, fontSize, fontColor, letterSpacing := txt.FontName, txt.FontSize, txt.FontColor, txt.LetterSpacing
+		if span.FontName != "" {
+			fontName = span.FontName
+		}
+		if span.FontSize > 0 {
+			fontSize = span.FontSize
+		}
+		if span.FontColor != "" {
+			fontColor = span.FontColor
+		}
+		if span.LetterSpacing != 0 {
+			letterSpacing = span.LetterSpacing
+		}
+		st, err := newStyle(fontName, fontSize*scale, fontColor, txt.Bold || span.Bold)
+		if err != nil {
+			return nil, nil, err
+		}
+		st.underline = txt.Underline || span.Underline
+		st.strikethrough = txt.Strikethrough || span.Strikethrough
+		st.letterSpacing = fixed.Int26_6(letterSpacing * scale * 64)
+		styles = append(styles, st)
+	}
+	return
+}
+
+// measureGlyphs 测量一段文字中每个字符的宽度，prev为前一段文字的最后一个字符
+// 每个字符使用包含该字符的第一个字体，表情序列使用表情图片，只有相同字体之间计算字距
+func measureGlyphs(style *textStyle, text string, prev *glyph) []glyph {
+	runes := []rune(text)
+	glyphs := make([]glyph, 0, len(runes))
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		g := glyph{r: r, style: style}
+		if path, n := style.emoji.match(runes[i:]); n > 0 && (isEmojiSequence(runes[i:]) || style.hasGlyph(r) == false) {
+			g.emoji = path
+			g.adv = fixed.Int26_6(style.size * 64)
+			if n > 1 {
+				g.text = string(runes[i : i+n])
+			}
+			i += n - 1
+		} else {
+			g.face = style.faceFor(r)
+			g.adv, _ = g.face.GlyphAdvance(r)
+			if prev != nil && prev.face == g.face {
+				g.kern = g.face.Kern(prev.r, r)
+			}
+		}
+		g.adv += style.letterSpacing + style.boldOffset()
+		glyphs = append(glyphs, g)
+		prev = &glyphs[len(glyphs)-1]
+	}
+	return glyphs
+}
+
+// measureSpans 测量所有片段的字符宽度
+func measureSpans(spans []*TextSpan, styles []*textStyle) (glyphs []glyph) {
+	for i, span := range spans {
+		var prev *glyph
+		if len(glyphs) > 0 {
+			prev = &glyphs[len(glyphs)-1]
+		}
+		glyphs = append(glyphs, measureGlyphs(styles[i], span.Content, prev)...)
+	}
+	return
+}
+
+// breakLines 按最大宽度换行 - 拉丁文字在单词边界换行，中日韩文字可在任意字符处换行
+// maxWidth小于等于0时只按换行符换行
+func breakLines(glyphs []glyph, maxWidth fixed.Int26_6) (lines []*textLine) {
+	for _, paragraph := range splitParagraphs(glyphs) {
+		start := len(lines)
+		line := new(textLine)
+		for _, seg := range splitSegments(paragraph) {
+			// 自动换行后的行首空白不绘制
+			if len(line.glyphs) == 0 && len(lines) > start && unicode.IsSpace(seg[0].r) {
+				continue
+			}
+			segWidth := fixed.Int26_6(0)
+			for i, g := range seg {
+				if i > 0 || len(line.glyphs) > 0 {
+					segWidth += g.kern
+				}
+				segWidth += g.adv
+			}
+			if maxWidth <= 0 || line.width+segWidth <= maxWidth {
+				line.push(seg...)
+				continue
+			}
+			// 放不下时在空白处换行，空白不保留到下一行
+			if unicode.IsSpace(seg[0].r) {
+				line.trimRight()
+				lines = append(lines, line)
+				line = new(textLine)
+				continue
+			}
+			if len(line.glyphs) > 0 {
+				line.trimRight()
+				lines = append(lines, line)
+				line = new(textLine)
+			}
+			// 单个单词超过整行宽度时按字符拆分
+			for _, g := range seg {
+				if len(line.glyphs) > 0 && line.width+g.kern+g.adv > maxWidth {
+					lines = append(lines, line)
+					line = new(textLine)
+				}
+				line.push(g)
+			}
+		}
+		line.trimRight()
+		line.last = true
+		lines = append(lines, line)
+	}
+	return
+}
+
+// splitParagraphs 按换行符拆分段落
+func splitParagraphs(glyphs []glyph) (paragraphs [][]glyph) {
+	start := 0
+	for i, g := range glyphs {
+		if g.r != '\n' {
+			continue
+		}
+		end := i
+		if end > start && glyphs[end-1].r == '\r' {
+			end--
+		}
+		paragraphs = append(paragraphs, glyphs[start:end])
+		start = i + 1
+	}
+	return append(paragraphs, glyphs[start:])
+}
+
+// splitSegments 将字符拆分为不可再分的换行单元
+// 连续的拉丁字母、数字、标点为一个单元；空白和中日韩字符各自为一个单元
+// 不能位于行首的标点并入前一个单元，不能位于行尾的标点与后一个字符合并
+func splitSegments(glyphs []glyph) (segs [][]glyph) {
+	var cur []glyph
+	for _, g := range glyphs {
+		if len(cur) > 0 {
+			prev := cur[len(cur)-1].r
+			split := false
+			switch {
+			case unicode.IsSpace(g.r) || unicode.IsSpace(prev):
+				split = true
+			case isNoLineStart(g.r) || isNoLineEnd(prev):
+				split = false
+			case isBreakAnywhere(g.r) || isBreakAnywhere(prev):
+				split = true
+			}
+			if split == true {
+				segs = append(segs, cur)
+				cur = nil
+			}
+		}
+		cur = append(cur, g)
+	}
+	if len(cur) > 0 {
+		segs = append(segs, cur)
+	}
+	return
+}
+
+// isBreakAnywhere 可以在任意位置换行的字符 - 中日韩文字、全角标点、表情符号
+func isBreakAnywhere(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) ||
+		(r >= 0x3000 && r <= 0x303F) || // 中日韩标点
+		(r >= 0xFF00 && r <= 0xFFEF) || // 全角字符
+		r >= 0x1F000 // 表情符号
+}
+
+// isNoLineStart 不能位于行首的标点
+func isNoLineStart(r rune) bool {
+	return strings.ContainsRune("，。、；：？！）」』】》〉”’…,.;:?!)]}%", r)
+}
+
+// isNoLineEnd 不能位于行尾的标点
+func isNoLineEnd(r rune) bool {
+	return strings.ContainsRune("（「『【《〈“‘([{", r)
+}
+
+// 省略号
+const ellipsis = '…'
+
+// textLayout 文本排版结果
+type textLayout struct {
+	base     *textStyle // 文本默认样式
+	fontSize float64    // 实际使用的字号 - shrink时可能小于设置的字号
+	lines    []*textLine
+	overflow bool // 文字是否超出文本框
+}
+
+// layoutText 按文本框大小排版，并根据Overflow处理超出的文字
+// shrink时所有片段的字号按相同比例缩小
+func (s *Service) layoutText(txt *Text) (layout *textLayout, err error) {
+	size := txt.FontSize
+	for {
+		base, styles, err := s.textStyles(txt, size/txt.FontSize)
+		if err != nil {
+			return nil, err
+		}
+		layout = &textLayout{base: base, fontSize: size}
+		layout.lines = breakLines(measureSpans(txt.textSpans(), styles), fixed.I(txt.Width))
+		for _, line := range layout.lines {
+			line.measure(base)
+		}
+		maxLines := layout.maxLines(txt)
+		if len(layout.lines) <= maxLines {
+			return layout, nil
+		}
+		// 逐步缩小字号
+		if txt.Overflow == OverflowShrink && size > txt.MinFontSize {
+			size = math.Max(size-1, txt.MinFontSize)
+			continue
+		}
+		layout.overflow = true
+		if txt.Overflow == OverflowEllipsis {
+			if maxLines < 1 {
+				maxLines = 1
+			}
+			layout.lines = layout.lines[:maxLines]
+			last := layout.lines[maxLines-1]
+			layout.lines[maxLines-1] = appendEllipsis(base, last, fixed.I(txt.Width))
+		} else if txt.LineCount > 0 && len(layout.lines) > txt.LineCount {
+			layout.lines = layout.lines[:txt.LineCount]
+		}
+		return layout, nil
+	}
+}
+
+// maxLines 文本框最多能放下的行数
+func (layout *textLayout) maxLines(txt *Text) int {
+	maxLines := len(layout.lines)
+	if txt.LineCount > 0 && txt.LineCount < maxLines {
+		maxLines = txt.LineCount
+	}
+	for maxLines > 0 && txt.Height > 0 && textHeight(layout.lines[:maxLines], txt.LineHeight) > txt.Height {
+		maxLines--
+	}
+	return maxLines
+}
+
+// baselines 每行基线距文字块顶部的距离 - 第一行为该行最大字号，之后每行增加该行最大字号*行间距
+func baselines(lines []*textLine, lineHeight float64) []int {
+	ys := make([]int, len(lines))
+	y := 0.0
+	for i, line := range lines {
+		if i == 0 {
+			y = line.size
+		} else {
+			y += line.size * lineHeight
+		}
+		ys[i] = int(y)
+	}
+	return ys
+}
+
+// textHeight 多行文字的高度 - 最后一行基线加上基线以下部分
+func textHeight(lines []*textLine, lineHeight float64) int {
+	if len(lines) == 0 {
+		return 0
+	}
+	last := len(lines) - 1
+	return baselines(lines, lineHeight)[last] + lines[last].descent.Ceil()
+}
+
+// verticalOffset 垂直对齐时文字块距文本框顶部的偏移
+func (layout *textLayout) verticalOffset(txt *Text) int {
+	if txt.Height <= 0 {
+		return 0
+	}
+	switch txt.VerticalAlign {
+	case VerticalAlignMiddle:
+		return (txt.Height - textHeight(layout.lines, txt.LineHeight)) / 2
+	case VerticalAlignBottom:
+		return txt.Height - textHeight(layout.lines, txt.LineHeight)
+	}
+	return 0
+}
+
+// appendEllipsis 在行尾追加省略号，超出宽度时去掉末尾字符
+// 省略号使用行尾字符的样式，空行使用文本默认样式
+func appendEllipsis(base *textStyle, line *textLine, maxWidth fixed.Int26_6) *textLine {
+	style := base
+	if len(line.glyphs) > 0 {
+		style = line.glyphs[len(line.glyphs)-1].style
+	}
+	dots := measureGlyphs(style, string(ellipsis), nil)[0]
+	glyphs := line.glyphs
+	truncated := &textLine{last: true}
+	for {
+		truncated.glyphs = nil
+		truncated.width = 0
+		truncated.push(glyphs...)
+		truncated.trimRight()
+		if maxWidth <= 0 || len(truncated.glyphs) == 0 || truncated.width+dots.adv <= maxWidth {
+			break
+		}
+		glyphs = truncated.glyphs[:len(truncated.glyphs)-1]
+	}
+	truncated.push(dots)
+	truncated.measure(base)
+	return truncated
+}
+
+// maxLineWidth 最宽一行的宽度
+func maxLineWidth(lines []*textLine) (width fixed.Int26_6) {
+	for _, line := range lines {
+		if line.width > width {
+			width = line.width
+		}
+	}
+	return
+}
+
+// glyphsWidth 一组字符的总宽度
+func glyphsWidth(glyphs []glyph) (width fixed.Int26_6) {
+	for i, g := range glyphs {
+		if i > 0 {
+			width += g.kern
+		}
+		width += g.adv
+	}
+	return
+}
+
+// alignLine 按对齐方式计算行首偏移，两端对齐时返回拉伸间距后的字符
+func alignLine(line *textLine, width fixed.Int26_6, align string) ([]glyph, fixed.Int26_6) {
+	extra := width - line.width
+	if extra <= 0 {
+		return line.glyphs, 0
+	}
+	switch align {
+	case AlignCenter:
+		return line.glyphs, extra / 2
+	case AlignRight:
+		return line.glyphs, extra
+	case AlignJustify:
+		if line.last == true {
+			break
+		}
+		return justifyGlyphs(line.glyphs, extra), 0
+	}
+	return line.glyphs, 0
+}
+
+// justifyGlyphs 将多余宽度平均分配到空白处，没有空白时分配到每个字符之间
+func justifyGlyphs(glyphs []glyph, extra fixed.Int26_6) []glyph {
+	if len(glyphs) < 2 {
+		return glyphs
+	}
+	gaps := make([]int, 0)
+	for i, g := range glyphs[:len(glyphs)-1] {
+		if unicode.IsSpace(g.r) {
+			gaps = append(gaps, i)
+		}
+	}
+	if len(gaps) == 0 {
+		for i := range glyphs[:len(glyphs)-1] {
+			gaps = append(gaps, i)
+		}
+	}
+	justified := make([]glyph, len(glyphs))
+	copy(justified, glyphs)
+	for k, i := range gaps {
+		// 余数分配到前面的间隔，保证总宽度与文本框一致
+		add := extra / fixed.Int26_6(len(gaps))
+		if fixed.Int26_6(k) < extra%fixed.Int26_6(len(gaps)) {
+			add++
+		}
+		justified[i].adv += add
+	}
+	return justified
+}
+
+// drawGlyphs 从基线位置dot开始绘制一行文字，只绘制clip区域内的部分
+// 彩色文字绘制到fill，文字形状同时绘制到mask用于描边和阴影
+func drawGlyphs(fill *image.RGBA, mask *image.Alpha, clip image.Rectangle, dot fixed.Point26_6, glyphs []glyph) {
+	start := dot.X
+	for i, g := range glyphs {
+		dot.X += g.kern
+		if i == 0 || glyphs[i-1].style != g.style {
+			start = dot.X
+		}
+		if g.emoji != "" {
+			drawEmoji(fill, mask, clip, dot, g)
+		} else {
+			drawGlyph(fill, mask, clip, dot, g)
+		}
+		dot.X += g.adv
+		// 样式相同的连续字符画一条下划线或删除线
+		if i == len(glyphs)-1 || glyphs[i+1].style != g.style {
+			drawDecorations(fill, mask, clip, g.style, start, dot.X, dot.Y)
+		}
+	}
+}
+
+// drawGlyph 绘制单个字符 - 加粗时水平偏移后重复绘制一次
+func drawGlyph(fill *image.RGBA, mask *image.Alpha, clip image.Rectangle, dot fixed.Point26_6, g glyph) {
+	src := image.NewUniform(g.style.color)
+	offsets := []fixed.Int26_6{0}
+	if g.style.bold == true {
+		offsets = append(offsets, g.style.boldOffset())
+	}
+	for _, dx := range offsets {
+		dr, glyphMask, maskp, _, ok := g.face.Glyph(fixed.Point26_6{X: dot.X + dx, Y: dot.Y}, g.r)
+		if ok == false {
+			continue
+		}
+		cr := dr.Intersect(clip)
+		if cr.Empty() == true {
+			continue
+		}
+		mp := maskp.Add(cr.Min.Sub(dr.Min))
+		draw.DrawMask(fill, cr, src, image.ZP, glyphMask, mp, draw.Over)
+		draw.DrawMask(mask, cr, image.Opaque, image.ZP, glyphMask, mp, draw.Over)
+	}
+}
+
+// drawDecorations 绘制x0到x1之间的下划线和删除线
+func drawDecorations(fill *image.RGBA, mask *image.Alpha, clip image.Rectangle, style *textStyle, x0, x1, baseline fixed.Int26_6) {
+	thickness := fixed.Int26_6(math.Max(style.size/16, 1) * 64)
+	ys := make([]fixed.Int26_6, 0, 2)
+	if style.underline == true {
+		ys = append(ys, baseline+fixed.Int26_6(style.size*0.12*64))
+	}
+	if style.strikethrough == true {
+		ys = append(ys, baseline-fixed.Int26_6(style.size*0.3*64))
+	}
+	for _, y := range ys {
+		r := image.Rect(x0.Round(), y.Round(), x1.Round(), (y + thickness).Round()).Intersect(clip)
+		draw.Draw(fill, r, image.NewUniform(style.color), image.ZP, draw.Over)
+		draw.Draw(mask, r, image.Opaque, image.ZP, draw.Over)
+	}
+}
@@ -0,0 +1,251 @@
+package service
+
+import (
+	"image"
+	"image/color"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// 等宽测试字体 - 中日韩字符宽20，其他字符宽10
+type fixedFace struct{}
+
+func (fixedFace) Close() error { return nil }
+
+func (fixedFace) Glyph(dot fixed.Point26_6, r rune) (image.Rectangle, image.Image, image.Point, fixed.Int26_6, bool) {
+	adv, _ := fixedFace{}.GlyphAdvance(r)
+	return image.Rectangle{}, nil, image.Point{}, adv, false
+}
+
+func (fixedFace) GlyphBounds(r rune) (fixed.Rectangle26_6, fixed.Int26_6, bool) {
+	adv, _ := fixedFace{}.GlyphAdvance(r)
+	return fixed.Rectangle26_6{}, adv, true
+}
+
+func (fixedFace) GlyphAdvance(r rune) (fixed.Int26_6, bool) {
+	if isBreakAnywhere(r) {
+		return fixed.I(20), true
+	}
+	return fixed.I(10), true
+}
+
+func (fixedFace) Kern(r0, r1 rune) fixed.Int26_6 { return 0 }
+
+func (fixedFace) Metrics() font.Metrics { return font.Metrics{} }
+
+// 测试用的默认样式
+var fixedStyle = &textStyle{face: fixedFace{}, size: 20}
+
+// 按测试样式测量文字
+func fixedGlyphs(content string) []glyph {
+	return measureGlyphs(fixedStyle, content, nil)
+}
+
+func TestBreakLines(t *testing.T) {
+	cases := []struct {
+		content string
+		width   int
+		want    []string
+	}{
+		{"hello world foo", 100, []string{"hello", "world foo"}},
+		{"爱就大声说出来", 60, []string{"爱就大", "声说出", "来"}},
+		{"你好，世界。", 40, []string{"你", "好，", "世", "界。"}},
+		{"abcdefghij", 40, []string{"abcd", "efgh", "ij"}},
+		{"第一行\nsecond line", 0, []string{"第一行", "second line"}},
+		{"价格 price 100元", 100, []string{"价格 price", "100元"}},
+	}
+	for _, c := range cases {
+		lines := breakLines(fixedGlyphs(c.content), fixed.I(c.width))
+		got := make([]string, 0, len(lines))
+		for _, l := range lines {
+			got = append(got, l.String())
+		}
+		if len(got) != len(c.want) {
+			t.Fatalf("%q: got %q, want %q", c.content, got, c.want)
+		}
+		for i := range got {
+			if got[i] != c.want[i] {
+				t.Fatalf("%q: got %q, want %q", c.content, got, c.want)
+			}
+		}
+	}
+}
+
+func TestAlignLine(t *testing.T) {
+	lines := breakLines(fixedGlyphs("ab cd 你好"), 0)
+	line := lines[0]
+	if line.width != fixed.I(100) {
+		t.Fatalf("line width got %v", line.width)
+	}
+	if _, offset := alignLine(line, fixed.I(120), AlignCenter); offset != fixed.I(10) {
+		t.Fatalf("center offset got %v", offset)
+	}
+	if _, offset := alignLine(line, fixed.I(120), AlignRight); offset != fixed.I(20) {
+		t.Fatalf("right offset got %v", offset)
+	}
+	// 段落最后一行不拉伸
+	if glyphs, _ := alignLine(line, fixed.I(120), AlignJustify); glyphs[2].adv != fixed.I(10) {
+		t.Fatalf("last line should not be justified")
+	}
+	line.last = false
+	glyphs, _ := alignLine(line, fixed.I(120), AlignJustify)
+	if glyphs[2].adv != fixed.I(20) || glyphs[5].adv != fixed.I(20) {
+		t.Fatalf("justified spaces got %v %v", glyphs[2].adv, glyphs[5].adv)
+	}
+}
+
+func TestGetTest(t *testing.T) {
+	// 旧的按字符数换行 - 汉字=2 字母=1
+	lines := (&Text{LineCount: 4, Content: "ab中文cd"}).GetTest()
+	if len(lines) != 2 || lines[0] != "ab中" || lines[1] != "文cd" {
+		t.Fatalf("got %q", lines)
+	}
+}
+
+func TestAppendEllipsis(t *testing.T) {
+	lines := breakLines(fixedGlyphs("hello world"), 0)
+	line := appendEllipsis(fixedStyle, lines[0], fixed.I(70))
+	if line.String() != "hello…" {
+		t.Fatalf("got %q", line.String())
+	}
+	if line.width > fixed.I(70) {
+		t.Fatalf("width %v exceeds 70", line.width)
+	}
+}
+
+func TestSpanLines(t *testing.T) {
+	big := &textStyle{face: fixedFace{}, size: 40, letterSpacing: fixed.I(2)}
+	bold := &textStyle{face: fixedFace{}, size: 20, bold: true}
+	spans := []*TextSpan{{Content: "price "}, {Content: "99"}, {Content: "元\n"}, {Content: "ok"}}
+	glyphs := measureSpans(spans, []*textStyle{fixedStyle, big, bold, fixedStyle})
+	// 字间距和加粗计入字符宽度
+	if glyphs[6].adv != fixed.I(12) || glyphs[8].adv != fixed.I(21) {
+		t.Fatalf("span advance got %v %v", glyphs[6].adv, glyphs[8].adv)
+	}
+	lines := breakLines(glyphs, fixed.I(80))
+	got := make([]string, 0, len(lines))
+	for _, l := range lines {
+		l.measure(fixedStyle)
+		got = append(got, l.String())
+	}
+	if len(got) != 3 || got[0] != "price" || got[1] != "99元" || got[2] != "ok" {
+		t.Fatalf("got %q", got)
+	}
+	// 行高按行内最大字号计算
+	if lines[0].size != 20 || lines[1].size != 40 {
+		t.Fatalf("line size got %v %v", lines[0].size, lines[1].size)
+	}
+	if ys := baselines(lines, 1.5); ys[0] != 20 || ys[1] != 80 || ys[2] != 110 {
+		t.Fatalf("baselines got %v", ys)
+	}
+}
+
+func TestEmojiGlyphs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "emoji")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"1f600.png", "2764.png", "1f468-200d-1f469.png"} {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	style := &textStyle{face: fixedFace{}, size: 20, emoji: getEmojiSet(dir)}
+	glyphs := measureGlyphs(style, "a😀❤️👨‍👩b", nil)
+	if len(glyphs) != 5 {
+		t.Fatalf("got %d glyphs", len(glyphs))
+	}
+	// FE0F可以省略，零宽连接符序列作为一个字符
+	if glyphs[1].emoji == "" || glyphs[2].emoji == "" || glyphs[3].text != "👨‍👩" || glyphs[4].r != 'b' {
+		t.Fatalf("emoji glyphs got %+v", glyphs)
+	}
+	if glyphs[3].adv != fixed.I(20) {
+		t.Fatalf("emoji advance got %v", glyphs[3].adv)
+	}
+}
+
+// 表情图片目录不存在时不会一直缓存，创建后可以重新读取
+func TestEmojiSetRetry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "emoji")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dir = filepath.Join(dir, "twemoji")
+	old := emojiRetryInterval
+	defer func() { emojiRetryInterval = old }()
+
+	emojiRetryInterval = time.Hour
+	if set := getEmojiSet(dir); set != nil {
+		t.Fatal("expected nil emoji set for missing dir")
+	}
+	if err = os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "1f600.png"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if set := getEmojiSet(dir); set != nil {
+		t.Fatal("expected nil emoji set before retry interval")
+	}
+	emojiRetryInterval = 0
+	set := getEmojiSet(dir)
+	if set == nil {
+		t.Fatal("expected emoji set after retry")
+	}
+	if path, n := set.match([]rune("😀")); path == "" || n != 1 {
+		t.Fatalf("match got %q %d", path, n)
+	}
+}
+
+func TestDilateMask(t *testing.T) {
+	// 圆形和抗锯齿边缘，和逐像素查找半径内最大值的结果一致
+	mask := image.NewAlpha(image.Rect(5, 5, 75, 65))
+	for y := 5; y < 65; y++ {
+		for x := 5; x < 75; x++ {
+			d := math.Hypot(float64(x-30), float64(y-30))
+			switch {
+			case d < 12:
+				mask.SetAlpha(x, y, color.Alpha{A: 0xff})
+			case d < 14:
+				mask.SetAlpha(x, y, color.Alpha{A: uint8((14 - d) * 127)})
+			case x > 60 && y > 50 && (x+y)%3 == 0:
+				mask.SetAlpha(x, y, color.Alpha{A: uint8(x * y)})
+			}
+		}
+	}
+	for _, width := range []float64{1, 2.5, 6, 10} {
+		got := dilateMask(mask, width)
+		r := int(math.Ceil(width))
+		for y := 5; y < 65; y++ {
+			for x := 5; x < 75; x++ {
+				var want float64
+				for dy := -r; dy <= r; dy++ {
+					for dx := -r; dx <= r; dx++ {
+						if !(image.Point{x + dx, y + dy}.In(mask.Bounds())) {
+							continue
+						}
+						weight := math.Min(width+0.5-math.Hypot(float64(dx), float64(dy)), 1)
+						if v := float64(mask.AlphaAt(x+dx, y+dy).A) * weight; weight > 0 && v > want {
+							want = v
+						}
+					}
+				}
+				if got.AlphaAt(x, y).A != uint8(want) {
+					t.Fatalf("width %v at (%d, %d) got %d, want %d", width, x, y, got.AlphaAt(x, y).A, uint8(want))
+				}
+			}
+		}
+	}
+	if r := alphaBounds(mask); r != image.Rect(17, 17, 75, 65) {
+		t.Fatalf("alpha bounds got %v", r)
+	}
+}
@@ -0,0 +1,200 @@
+package template
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/naoina/toml"
+	"github.com/shiguanghuxian/poster/program/logger"
+)
+
+// 模板目录 - 每个文件一个模板，模板名为文件名(不含扩展名)
+// json文件格式同PUT /templates/:name的请求体，toml文件为description和[param]表
+
+// reloadDelay 文件变化后等待的时间，编辑器保存时会连续产生多个事件
+const reloadDelay = 200 * time.Millisecond
+
+// fileTemplate 模板文件内容
+type fileTemplate struct {
+	Description string                 `json:"description" toml:"description"`
+	Param       map[string]interface{} `json:"param" toml:"param"`
+}
+
+// templateFileName 模板文件对应的模板名，不是模板文件时返回空
+func templateFileName(path string) string {
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext != ".json" && ext != ".toml" {
+		return ""
+	}
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	if nameRegexp.MatchString(name) == false {
+		return ""
+	}
+	return name
+}
+
+// readTemplateFile 读取并解析模板文件
+func readTemplateFile(path string) (t *Template, err error) {
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+	ft := new(fileTemplate)
+	if strings.ToLower(filepath.Ext(path)) == ".toml" {
+		err = toml.Unmarshal(body, ft)
+	} else {
+		err = json.Unmarshal(body, ft)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Invalid template file %s: %v", filepath.Base(path), err)
+	}
+	if ft.Param == nil {
+		return nil, fmt.Errorf("Invalid template file %s: param is required", filepath.Base(path))
+	}
+	param, err := json.Marshal(ft.Param)
+	if err != nil {
+		return
+	}
+	return &Template{
+		Name:        templateFileName(path),
+		Description: ft.Description,
+		Param:       param,
+		File:        path,
+	}, nil
+}
+
+// LoadDir 加载目录中的模板文件并监听变化 - 目录不存在时创建
+// 文件有错误时记录日志并跳过，修改后的文件有错误时继续使用上一个正确的版本
+func (s *Store) LoadDir(dir string) (err error) {
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, f := range files {
+		if f.IsDir() == true || templateFileName(f.Name()) == "" {
+			continue
+		}
+		s.loadFile(filepath.Join(dir, f.Name()))
+	}
+	s.removeMissingFiles()
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return
+	}
+	if err = watcher.Add(dir); err != nil {
+		watcher.Close()
+		return
+	}
+	s.mu.Lock()
+	if s.watcher != nil {
+		s.watcher.Close()
+	}
+	s.watcher = watcher
+	s.mu.Unlock()
+	go s.watch(watcher)
+	return
+}
+
+// Close 停止监听模板目录
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.watcher == nil {
+		return nil
+	}
+	err := s.watcher.Close()
+	s.watcher = nil
+	return err
+}
+
+// watch 处理目录变化事件 - 同一文件的多个事件合并为一次重新加载
+func (s *Store) watch(watcher *fsnotify.Watcher) {
+	pending := make(map[string]bool)
+	timer := time.NewTimer(reloadDelay)
+	timer.Stop()
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if ok == false {
+				return
+			}
+			if templateFileName(event.Name) == "" {
+				continue
+			}
+			pending[event.Name] = true
+			timer.Reset(reloadDelay)
+		case err, ok := <-watcher.Errors:
+			if ok == false {
+				return
+			}
+			logger.Log.Errorw("监听模板目录错误", "err", err)
+		case <-timer.C:
+			for path := range pending {
+				if _, err := os.Stat(path); os.IsNotExist(err) {
+					s.removeFile(path)
+				} else {
+					s.loadFile(path)
+				}
+			}
+			pending = make(map[string]bool)
+		}
+	}
+}
+
+// loadFile 加载模板文件 - 检查通过后替换模板
+func (s *Store) loadFile(path string) {
+	t, err := readTemplateFile(path)
+	if err == nil {
+		_, err = s.save(t)
+	}
+	if err != nil {
+		logger.Log.Errorw("加载模板文件错误，继续使用上一个版本", "err", err, "file", path)
+		return
+	}
+	logger.Log.Infow("加载模板文件", "name", t.Name, "file", path)
+}
+
+// removeFile 模板文件删除后清除latest - 保留所有版本，指定版本号的引用仍可以使用，再次添加文件时版本号继续递增
+func (s *Store) removeFile(path string) {
+	name := templateFileName(path)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tv, ok := s.templates[name]
+	if ok == false {
+		return
+	}
+	if tv.file() == path {
+		tv.latest = 0
+		logger.Log.Infow("模板文件已删除", "name", name, "file", path)
+		if err := s.persist(); err != nil {
+			logger.Log.Errorw("写入模板存储文件错误", "err", err, "name", name)
+		}
+	}
+}
+
+// removeMissingFiles 服务停止期间删除的模板文件 - 清除latest，和运行中删除文件相同
+func (s *Store) removeMissingFiles() {
+	s.mu.RLock()
+	var missing []string
+	for _, tv := range s.templates {
+		if path := tv.file(); path != "" {
+			if _, err := os.Stat(path); os.IsNotExist(err) == true {
+				missing = append(missing, path)
+			}
+		}
+	}
+	s.mu.RUnlock()
+	for _, path := range missing {
+		s.removeFile(path)
+	}
+}
@@ -0,0 +1,144 @@
+package template
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shiguanghuxian/poster/program/logger"
+)
+
+func TestMain(m *testing.M) {
+	_, err := logger.InitLogger("", false)
+	if err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+const tomlTemplate = `description = "toml模板"
+
+[param]
+width = 400
+height = 300
+
+[param.background]
+color = "{{bg}}"
+
+[[param.layers]]
+[param.layers.text]
+width = 300
+font_size = 20
+content = "{{title}}"
+`
+
+// waitFor 等待目录变化被处理
+func waitFor(t *testing.T, msg string, cond func() bool) {
+	for i := 0; i < 50; i++ {
+		if cond() == true {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatal(msg)
+}
+
+func TestLoadDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "poster-templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, body string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("card.json", `{"description": "json模板", "param": {"width": 200, "layers": [{"text": {"width": 100, "content": "{{name}}"}}]}}`)
+	write("banner.toml", tomlTemplate)
+	write("broken.json", `{"param": `)
+	write("readme.txt", `not a template`)
+
+	s := NewStore()
+	if err = s.LoadDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if list := s.List(); len(list) != 2 || list[0].Name != "banner" || list[1].Name != "card" {
+		t.Fatalf("list got %v", list)
+	}
+	param, err := s.Render("banner", map[string]interface{}{"bg": "#FFFFFF", "title": "标题"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if param.Width != 400 || param.Background.Color != "#FFFFFF" || param.Layers[0].Text.Content != "标题" {
+		t.Fatalf("toml template got %+v", param)
+	}
+	// 模板文件中的模板不能通过接口修改
+	if _, err = s.Save(&Template{Name: "card", Param: []byte(`{}`)}); err != ErrReadOnly {
+		t.Fatalf("save file template got %v", err)
+	}
+	if err = s.Delete("card"); err != ErrReadOnly {
+		t.Fatalf("delete file template got %v", err)
+	}
+
+	// 修改后重新加载
+	write("card.json", `{"param": {"width": 300, "layers": [{"text": {"width": 100, "content": "{{nickname}}"}}]}}`)
+	waitFor(t, "card.json not reloaded", func() bool {
+		t, err := s.Get("card")
+		return err == nil && len(t.Variables) == 1 && t.Variables[0] == "nickname"
+	})
+	// 重新加载生成新版本，旧版本保留
+	if old, err := s.Get("card@1"); err != nil || old.Variables[0] != "name" {
+		t.Fatalf("card@1 got %v %v", old, err)
+	}
+	if _, err = s.Rollback("card", 1); err != ErrReadOnly {
+		t.Fatalf("rollback file template got %v", err)
+	}
+	// 文件有错误时继续使用上一个版本
+	for _, body := range []string{
+		`{"param": {"width": "abc"}}`,
+		`{"param": {"width": 100, "layers": [{"text": {"width": 100, "content": "{{nickname}}", "font_color": "#12"}}]}}`,
+		`{"param": {"width": 100, "layers": [{"txt": {"content": "{{nickname}}"}}]}}`,
+	} {
+		write("card.json", body)
+		time.Sleep(5 * reloadDelay)
+		if param, err = s.Render("card", map[string]interface{}{"nickname": "a"}); err != nil || param.Width != 300 {
+			t.Fatalf("invalid file %s should keep last version, got %v", body, err)
+		}
+	}
+	// 新增和删除文件
+	write("poster.json", `{"param": {"width": 100}}`)
+	if err = os.Remove(filepath.Join(dir, "banner.toml")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "directory changes not applied", func() bool {
+		_, errPoster := s.Get("poster")
+		_, errBanner := s.Get("banner")
+		return errPoster == nil && errBanner == ErrNotFound
+	})
+	// 删除文件后保留版本，指定版本号仍可以使用
+	if list := s.List(); len(list) != 2 || list[0].Name != "card" || list[1].Name != "poster" {
+		t.Fatalf("list after remove got %v", list)
+	}
+	if _, err = s.Render("banner@1", map[string]interface{}{"bg": "#FFFFFF", "title": "标题"}); err != nil {
+		t.Fatalf("banner@1 after remove got %v", err)
+	}
+	if _, err = s.Get("banner@latest"); err != ErrNotFound {
+		t.Fatalf("banner@latest after remove got %v", err)
+	}
+	if _, err = s.Rollback("banner", 1); err != ErrNotFound {
+		t.Fatalf("rollback removed template got %v", err)
+	}
+	if versions, latest, err := s.Versions("banner"); err != nil || latest != 0 || len(versions) != 1 {
+		t.Fatalf("versions after remove got %v %d %v", versions, latest, err)
+	}
+	// 再次添加文件时版本号继续递增
+	write("banner.toml", tomlTemplate)
+	waitFor(t, "banner.toml not reloaded", func() bool {
+		t, err := s.Get("banner")
+		return err == nil && t.Version == 2
+	})
+}
@@ -0,0 +1,300 @@
+package template
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// 图层指令 - 写在layers的图层中，生成海报参数前处理并删除
+const (
+	DirectiveIf     = "if"     // 变量有值时才显示图层，"!变量名"表示变量没有值时显示
+	DirectiveRepeat = "repeat" // 按列表变量重复图层
+)
+
+// MaxRepeat 一个图层最多重复的次数
+const MaxRepeat = 100
+
+// layerObjects 图层中带有top、left的元素
+var layerObjects = []string{"text", "image", "qr_code", "wx_qr_code", "shape"}
+
+// conditionRegexp if指令 - 变量名前可以加!取反
+var conditionRegexp = regexp.MustCompile(`^\s*(!?)\s*([A-Za-z_][A-Za-z0-9_.]*)\s*$`)
+
+// scopeNameRegexp repeat中列表项和序号的变量名
+var scopeNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Repeat 重复图层 - 列表中的每一项生成一个图层，第i项的top、left偏移 i*Step
+type Repeat struct {
+	Items string `json:"items"`           // 列表变量名
+	As    string `json:"as,omitempty"`    // 列表项的变量名 - 默认item，列表项为对象时使用 {{item.name}}
+	Index string `json:"index,omitempty"` // 序号的变量名 - 默认index，从0开始
+	Step  Offset `json:"step,omitempty"`  // 每一项相对上一项的偏移
+}
+
+// Offset 偏移
+type Offset struct {
+	Top  int `json:"top,omitempty"`
+	Left int `json:"left,omitempty"`
+}
+
+// layerDirectives 图层上的指令
+type layerDirectives struct {
+	negate bool    // if取反
+	cond   string  // if变量名 - 为空时总是显示
+	repeat *Repeat // 为空时不重复
+}
+
+// parseDirectives 解析图层上的指令，返回指令和删除指令后的图层
+func parseDirectives(layer map[string]interface{}) (d *layerDirectives, rest map[string]interface{}, err error) {
+	d = new(layerDirectives)
+	rest = make(map[string]interface{}, len(layer))
+	for k, v := range layer {
+		if k != DirectiveIf && k != DirectiveRepeat {
+			rest[k] = v
+		}
+	}
+	if v, ok := layer[DirectiveIf]; ok == true {
+		cond, ok := v.(string)
+		m := conditionRegexp.FindStringSubmatch(cond)
+		if ok == false || m == nil {
+			return nil, nil, fmt.Errorf("Invalid if directive -- %v", v)
+		}
+		d.negate = m[1] == "!"
+		d.cond = m[2]
+	}
+	if v, ok := layer[DirectiveRepeat]; ok == true {
+		d.repeat, err = parseRepeat(v)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return
+}
+
+// parseRepeat 解析repeat指令
+func parseRepeat(v interface{}) (repeat *Repeat, err error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	repeat = new(Repeat)
+	if err = json.Unmarshal(body, repeat); err != nil {
+		return nil, fmt.Errorf("Invalid repeat directive: %v", err)
+	}
+	if conditionRegexp.MatchString(repeat.Items) == false || strings.Contains(repeat.Items, "!") == true {
+		return nil, fmt.Errorf("Invalid repeat items -- %s", repeat.Items)
+	}
+	if repeat.As == "" {
+		repeat.As = "item"
+	}
+	if repeat.Index == "" {
+		repeat.Index = "index"
+	}
+	if scopeNameRegexp.MatchString(repeat.As) == false || scopeNameRegexp.MatchString(repeat.Index) == false {
+		return nil, errors.New("The repeat as and index must be variable names without dots")
+	}
+	if repeat.As == repeat.Index {
+		return nil, errors.New("The repeat as and index cannot be the same")
+	}
+	repeat.Items = strings.TrimSpace(repeat.Items)
+	return
+}
+
+// scoped 变量名是否为repeat中列表项或序号的变量
+func (repeat *Repeat) scoped(name string) bool {
+	return name == repeat.As || name == repeat.Index || strings.HasPrefix(name, repeat.As+".") == true
+}
+
+// render 处理图层指令并替换占位符
+func render(v interface{}, variables map[string]interface{}) (interface{}, error) {
+	m, ok := v.(map[string]interface{})
+	if ok == false {
+		return substitute(v, variables)
+	}
+	out := make(map[string]interface{}, len(m))
+	for k, val := range m {
+		layers, ok := val.([]interface{})
+		if k != "layers" || ok == false {
+			replaced, err := substitute(val, variables)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = replaced
+			continue
+		}
+		expanded, err := expandLayers(layers, variables)
+		if err != nil {
+			return nil, err
+		}
+		out[k] = expanded
+	}
+	return out, nil
+}
+
+// expandLayers 删除条件不成立的图层，展开重复的图层
+func expandLayers(layers []interface{}, variables map[string]interface{}) ([]interface{}, error) {
+	list := make([]interface{}, 0, len(layers))
+	for i, layer := range layers {
+		m, ok := layer.(map[string]interface{})
+		if ok == false {
+			replaced, err := substitute(layer, variables)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, replaced)
+			continue
+		}
+		d, rest, err := parseDirectives(m)
+		if err != nil {
+			return nil, fmt.Errorf("layers[%d]: %v", i, err)
+		}
+		if d.cond != "" && truthy(variables, d.cond) == d.negate {
+			continue
+		}
+		if d.repeat == nil {
+			replaced, err := substitute(rest, variables)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, replaced)
+			continue
+		}
+		items, err := repeatItems(variables, d.repeat.Items)
+		if err != nil {
+			return nil, fmt.Errorf("layers[%d]: %v", i, err)
+		}
+		for j, item := range items {
+			scope := make(map[string]interface{}, len(variables)+2)
+			for k, v := range variables {
+				scope[k] = v
+			}
+			scope[d.repeat.As] = item
+			scope[d.repeat.Index] = j
+			replaced, err := substitute(rest, scope)
+			if err != nil {
+				return nil, err
+			}
+			offsetLayer(replaced.(map[string]interface{}), j*d.repeat.Step.Top, j*d.repeat.Step.Left)
+			list = append(list, replaced)
+		}
+	}
+	return list, nil
+}
+
+// truthy 变量是否有值 - 不存在、空字符串、false、0、空列表为没有值
+func truthy(variables map[string]interface{}, name string) bool {
+	v, ok := lookup(variables, name)
+	if ok == false {
+		return false
+	}
+	switch val := v.(type) {
+	case []interface{}:
+		return len(val) > 0
+	case map[string]interface{}:
+		return len(val) > 0
+	}
+	str, err := variableString(name, v)
+	if err != nil {
+		return false
+	}
+	return str != "" && str != "false" && str != "0"
+}
+
+// repeatItems repeat的列表变量
+func repeatItems(variables map[string]interface{}, name string) ([]interface{}, error) {
+	v, ok := lookup(variables, name)
+	if ok == false {
+		return nil, fmt.Errorf("Missing template variable -- %s", name)
+	}
+	items, ok := v.([]interface{})
+	if ok == false {
+		return nil, fmt.Errorf("The template variable %s must be a list", name)
+	}
+	if len(items) > MaxRepeat {
+		return nil, fmt.Errorf("The template variable %s has more than %d items", name, MaxRepeat)
+	}
+	return items, nil
+}
+
+// offsetLayer 图层中元素的top、left增加偏移
+func offsetLayer(layer map[string]interface{}, top, left int) {
+	if top == 0 && left == 0 {
+		return
+	}
+	for _, key := range layerObjects {
+		obj, ok := layer[key].(map[string]interface{})
+		if ok == false {
+			continue
+		}
+		obj["top"] = addNumber(obj["top"], top)
+		obj["left"] = addNumber(obj["left"], left)
+	}
+}
+
+// addNumber 数字加上偏移 - 不是数字时不修改，由参数检查报错
+func addNumber(v interface{}, delta int) interface{} {
+	switch val := v.(type) {
+	case nil:
+		return delta
+	case json.Number:
+		if n, err := val.Int64(); err == nil {
+			return n + int64(delta)
+		}
+		if f, err := val.Float64(); err == nil {
+			return f + float64(delta)
+		}
+	case float64:
+		return val + float64(delta)
+	case int:
+		return val + delta
+	}
+	return v
+}
+
+// stripDirectives 删除所有图层上的指令，用于保存时检查参数，返回删除指令后的参数和模板变量名
+// 模板变量包括指令中的变量，不包括repeat中列表项和序号的变量
+func stripDirectives(v interface{}) (stripped interface{}, names []string, err error) {
+	m, ok := v.(map[string]interface{})
+	if ok == false {
+		return v, placeholderNames(v), nil
+	}
+	out := make(map[string]interface{}, len(m))
+	for k, val := range m {
+		layers, ok := val.([]interface{})
+		if k != "layers" || ok == false {
+			out[k] = val
+			names = append(names, placeholderNames(val)...)
+			continue
+		}
+		list := make([]interface{}, 0, len(layers))
+		for i, layer := range layers {
+			lm, ok := layer.(map[string]interface{})
+			if ok == false {
+				list = append(list, layer)
+				names = append(names, placeholderNames(layer)...)
+				continue
+			}
+			d, rest, err := parseDirectives(lm)
+			if err != nil {
+				return nil, nil, fmt.Errorf("layers[%d]: %v", i, err)
+			}
+			if d.cond != "" {
+				names = append(names, d.cond)
+			}
+			if d.repeat != nil {
+				names = append(names, d.repeat.Items)
+			}
+			for _, name := range placeholderNames(rest) {
+				if d.repeat == nil || d.repeat.scoped(name) == false {
+					names = append(names, name)
+				}
+			}
+			list = append(list, rest)
+		}
+		out[k] = list
+	}
+	return out, uniqueSorted(names), nil
+}
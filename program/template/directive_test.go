@@ -0,0 +1,103 @@
+package template
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+const testDirectiveParam = `{
+	"width": 400,
+	"height": 600,
+	"layers": [
+		{"text": {"width": 300, "content": "{{title}}"}},
+		{"if": "discount", "text": {"top": 40, "width": 100, "content": "立减{{discount}}"}},
+		{"if": "!discount", "text": {"top": 40, "width": 100, "content": "原价"}},
+		{"repeat": {"items": "products", "as": "p", "step": {"top": 50}}, "text": {"top": 100, "left": 10, "width": 300, "content": "{{index}}. {{p.name}} {{p.price}}元"}},
+		{"if": "avatars", "repeat": {"items": "avatars", "step": {"left": 30}}, "image": {"top": 500, "width": 24, "height": 24, "image_url": "{{item}}"}}
+	]
+}`
+
+func TestDirectives(t *testing.T) {
+	s := NewStore()
+	saved, err := s.Save(&Template{Name: "products", Param: json.RawMessage(testDirectiveParam)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 列表项和序号的变量不是模板变量
+	want := []string{"avatars", "discount", "products", "title"}
+	if len(saved.Variables) != len(want) {
+		t.Fatalf("variables got %v", saved.Variables)
+	}
+	for i := range want {
+		if saved.Variables[i] != want[i] {
+			t.Fatalf("variables got %v", saved.Variables)
+		}
+	}
+
+	var variables map[string]interface{}
+	err = json.Unmarshal([]byte(`{
+		"title": "清单",
+		"discount": 0,
+		"products": [{"name": "苹果", "price": 5}, {"name": "香蕉", "price": 3.5}, {"name": "梨", "price": 4}]
+	}`), &variables)
+	if err != nil {
+		t.Fatal(err)
+	}
+	param, err := s.Render("products", variables)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 标题、原价、3个商品，没有头像
+	if len(param.Layers) != 5 {
+		t.Fatalf("layers got %d", len(param.Layers))
+	}
+	if got := param.Layers[1].Text.Content; got != "原价" {
+		t.Fatalf("if layer got %s", got)
+	}
+	for i, content := range []string{"0. 苹果 5元", "1. 香蕉 3.5元", "2. 梨 4元"} {
+		text := param.Layers[2+i].Text
+		if text.Content != content || text.Top != 100+50*i || text.Left != 10 {
+			t.Fatalf("repeat %d got %s top %d left %d", i, text.Content, text.Top, text.Left)
+		}
+	}
+
+	variables["discount"] = "10"
+	variables["products"] = []interface{}{}
+	variables["avatars"] = []interface{}{"https://example.com/1.png", "https://example.com/2.png"}
+	if param, err = s.Render("products", variables); err != nil {
+		t.Fatal(err)
+	}
+	if len(param.Layers) != 4 || param.Layers[1].Text.Content != "立减10" {
+		t.Fatalf("layers got %d", len(param.Layers))
+	}
+	if img := param.Layers[3].Image; img.ImageURL != "https://example.com/2.png" || img.Left != 30 || img.Top != 500 {
+		t.Fatalf("avatar got %+v", img)
+	}
+
+	// repeat的变量必须是列表
+	variables["products"] = "abc"
+	if _, err = s.Render("products", variables); err == nil {
+		t.Fatal("repeat a string should fail")
+	}
+	delete(variables, "products")
+	if _, err = s.Render("products", variables); err == nil {
+		t.Fatal("missing repeat items should fail")
+	}
+}
+
+func TestCheckDirectives(t *testing.T) {
+	list := []string{
+		`{"layers": [{"if": 1, "text": {"content": "a"}}]}`,
+		`{"layers": [{"if": "a b", "text": {"content": "a"}}]}`,
+		`{"layers": [{"repeat": "items", "text": {"content": "a"}}]}`,
+		`{"layers": [{"repeat": {"items": "!items"}, "text": {"content": "a"}}]}`,
+		`{"layers": [{"repeat": {"items": "items", "as": "a.b"}, "text": {"content": "a"}}]}`,
+		`{"layers": [{"repeat": {"items": "items", "as": "i", "index": "i"}, "text": {"content": "a"}}]}`,
+		`{"layers": [{"repeat": {"items": "items", "step": {"top": "1"}}, "text": {"content": "a"}}]}`,
+	}
+	for _, param := range list {
+		if err := check(&Template{Name: "a", Param: json.RawMessage(param)}); err == nil {
+			t.Fatalf("%s should fail", param)
+		}
+	}
+}
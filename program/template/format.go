@@ -0,0 +1,263 @@
+package template
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// 格式化函数在占位符中使用 {{变量名 | 函数 参数...}}，可以连续使用多个，参数包含空格时用单引号或双引号
+// 例如 {{price | currency}}、{{created_at | date '2006年1月2日 15:04'}}、{{nickname | truncate 6 | default '游客'}}
+
+// DefaultDateLayout date默认格式
+const DefaultDateLayout = "2006年1月2日"
+
+// maxPadWidth pad最大宽度
+const maxPadWidth = 100
+
+// formatFunc 格式化函数 - 参数在值为空时也要检查，保存模板时会用空值检查格式
+type formatFunc func(value string, args []string) (string, error)
+
+// formatter 格式化函数和参数个数
+type formatter struct {
+	fn      formatFunc
+	minArgs int
+	maxArgs int
+}
+
+// formatters 可用的格式化函数
+var formatters = map[string]*formatter{
+	"currency": {fn: formatCurrency, minArgs: 0, maxArgs: 1}, // 金额保留两位小数 - 参数为货币符号，默认¥
+	"date":     {fn: formatDate, minArgs: 0, maxArgs: 1},     // 日期 - 参数为go时间格式，默认2006年1月2日
+	"truncate": {fn: formatTruncate, minArgs: 1, maxArgs: 2}, // 截取前N个字符 - 第二个参数为超出时的后缀，默认…
+	"upper":    {fn: formatUpper, minArgs: 0, maxArgs: 0},    // 转为大写
+	"default":  {fn: formatDefault, minArgs: 1, maxArgs: 1},  // 值为空或变量不存在时使用参数
+	"pad":      {fn: formatPad, minArgs: 1, maxArgs: 2},      // 左侧补齐到N个字符 - 第二个参数为补齐的字符，默认0
+}
+
+// format 占位符中的一个格式化函数
+type format struct {
+	name string
+	args []string
+}
+
+// parseFormats 解析占位符中变量名后的格式化函数，如 | truncate 6 | default '游客'
+func parseFormats(expr string) (formats []*format, err error) {
+	tokens, err := splitFormatTokens(expr)
+	if err != nil {
+		return
+	}
+	for _, token := range tokens {
+		if token.pipe == true {
+			formats = append(formats, new(format))
+			continue
+		}
+		if len(formats) == 0 {
+			return nil, fmt.Errorf("Unexpected format argument -- %s", token.value)
+		}
+		f := formats[len(formats)-1]
+		if f.name == "" {
+			f.name = token.value
+		} else {
+			f.args = append(f.args, token.value)
+		}
+	}
+	for _, f := range formats {
+		if f.name == "" {
+			return nil, errors.New("Missing format name after |")
+		}
+		fm, ok := formatters[f.name]
+		if ok == false {
+			return nil, fmt.Errorf("Unknown format -- %s", f.name)
+		}
+		if len(f.args) < fm.minArgs || len(f.args) > fm.maxArgs {
+			return nil, fmt.Errorf("The %s format takes %d to %d arguments", f.name, fm.minArgs, fm.maxArgs)
+		}
+	}
+	return
+}
+
+// formatToken 格式化表达式中的 | 或参数
+type formatToken struct {
+	pipe  bool
+	value string
+}
+
+// splitFormatTokens 拆分格式化表达式 - 单引号中的内容原样保留，双引号按go字符串转义
+func splitFormatTokens(expr string) (tokens []*formatToken, err error) {
+	for i := 0; i < len(expr); {
+		switch c := expr[i]; {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '|':
+			tokens = append(tokens, &formatToken{pipe: true})
+			i++
+		case c == '\'':
+			end := strings.IndexByte(expr[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("Unterminated quote in format -- %s", expr)
+			}
+			tokens = append(tokens, &formatToken{value: expr[i+1 : i+1+end]})
+			i += end + 2
+		case c == '"':
+			end := i + 1
+			for ; end < len(expr) && expr[end] != '"'; end++ {
+				if expr[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(expr) {
+				return nil, fmt.Errorf("Unterminated quote in format -- %s", expr)
+			}
+			value, err := strconv.Unquote(expr[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("Invalid quoted argument in format -- %s", expr[i:end+1])
+			}
+			tokens = append(tokens, &formatToken{value: value})
+			i = end + 1
+		default:
+			end := strings.IndexAny(expr[i:], " \t\n|")
+			if end < 0 {
+				end = len(expr) - i
+			}
+			tokens = append(tokens, &formatToken{value: expr[i : i+end]})
+			i += end
+		}
+	}
+	return
+}
+
+// hasDefault 是否使用了default - 使用时变量可以不传
+func hasDefault(formats []*format) bool {
+	for _, f := range formats {
+		if f.name == "default" {
+			return true
+		}
+	}
+	return false
+}
+
+// applyFormats 依次执行格式化函数
+func applyFormats(value string, formats []*format) (string, error) {
+	var err error
+	for _, f := range formats {
+		value, err = formatters[f.name].fn(value, f.args)
+		if err != nil {
+			return "", err
+		}
+	}
+	return value, nil
+}
+
+// formatCurrency 金额保留两位小数，加上货币符号
+func formatCurrency(value string, args []string) (string, error) {
+	symbol := "¥"
+	if len(args) > 0 {
+		symbol = args[0]
+	}
+	if value == "" {
+		return "", nil
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		return "", fmt.Errorf("The currency format requires a number -- %s", value)
+	}
+	return symbol + strconv.FormatFloat(f, 'f', 2, 64), nil
+}
+
+// dateLayouts date支持的输入格式
+var dateLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02", "2006/01/02 15:04:05", "2006/01/02"}
+
+// compactDateLayout 8位数字的日期，如20231115 - 优先于时间戳解析
+const compactDateLayout = "20060102"
+
+// timestampRegexp unix时间戳，可以有小数
+var timestampRegexp = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?$`)
+
+// formatDate 日期格式化 - 值为unix时间戳（秒或毫秒，可以有小数）、20060102或常见日期格式，没有时区时使用服务所在时区
+func formatDate(value string, args []string) (string, error) {
+	layout := DefaultDateLayout
+	if len(args) > 0 {
+		layout = args[0]
+	}
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", nil
+	}
+	if len(value) == len(compactDateLayout) {
+		if t, err := time.ParseInLocation(compactDateLayout, value, time.Local); err == nil {
+			return t.Format(layout), nil
+		}
+	}
+	if timestampRegexp.MatchString(value) == true {
+		f, err := strconv.ParseFloat(value, 64)
+		if err == nil {
+			// 超过13位认为是毫秒
+			if math.Abs(f) >= 1e12 {
+				f /= 1000
+			}
+			// 小数部分精确到毫秒，避免浮点误差
+			sec, frac := math.Modf(f)
+			return time.Unix(int64(sec), int64(math.Round(frac*1e3))*int64(time.Millisecond)).Format(layout), nil
+		}
+	}
+	for _, l := range dateLayouts {
+		if t, err := time.ParseInLocation(l, value, time.Local); err == nil {
+			return t.Format(layout), nil
+		}
+	}
+	return "", fmt.Errorf("The date format requires a unix timestamp or date -- %s", value)
+}
+
+// formatTruncate 截取前N个字符，超出时加上后缀
+func formatTruncate(value string, args []string) (string, error) {
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 1 {
+		return "", fmt.Errorf("The truncate length must be a positive integer -- %s", args[0])
+	}
+	suffix := "…"
+	if len(args) > 1 {
+		suffix = args[1]
+	}
+	if utf8.RuneCountInString(value) <= n {
+		return value, nil
+	}
+	return string([]rune(value)[:n]) + suffix, nil
+}
+
+// formatUpper 转为大写
+func formatUpper(value string, args []string) (string, error) {
+	return strings.ToUpper(value), nil
+}
+
+// formatDefault 值为空时使用默认值
+func formatDefault(value string, args []string) (string, error) {
+	if value == "" {
+		return args[0], nil
+	}
+	return value, nil
+}
+
+// formatPad 左侧补齐到N个字符
+func formatPad(value string, args []string) (string, error) {
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 1 || n > maxPadWidth {
+		return "", fmt.Errorf("The pad width must be between 1 and %d -- %s", maxPadWidth, args[0])
+	}
+	char := "0"
+	if len(args) > 1 {
+		char = args[1]
+	}
+	if utf8.RuneCountInString(char) != 1 {
+		return "", fmt.Errorf("The pad character must be one character -- %s", char)
+	}
+	if count := n - utf8.RuneCountInString(value); count > 0 {
+		value = strings.Repeat(char, count) + value
+	}
+	return value, nil
+}
@@ -0,0 +1,89 @@
+package template
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestFormats(t *testing.T) {
+	local := time.Local
+	time.Local = time.FixedZone("CST", 8*3600)
+	defer func() {
+		time.Local = local
+	}()
+	variables := map[string]interface{}{
+		"price":    json.Number("1234.5"),
+		"ts":       json.Number("1700000000"),
+		"ms":       int64(1700000000000),
+		"day":      "2023-11-15",
+		"compact":  json.Number("20231115"),
+		"float_ts": json.Number("1700000000.5"),
+		"bad_day":  "2023-13-45",
+		"bad_ts":   "1700000000.5.1",
+		"nickname": "一个很长的昵称abc",
+		"code":     "ab12",
+		"no":       7,
+		"empty":    "",
+	}
+	list := map[string]string{
+		"{{price | currency}}":                           "¥1234.50",
+		"{{ price|currency '$' }}":                       "$1234.50",
+		"{{ts | date}}":                                  "2023年11月15日",
+		`{{ms | date "2006-01-02 15:04"}}`:               "2023-11-15 06:13",
+		"{{day | date '1月2日'}}":                          "11月15日",
+		"{{compact | date}}":                             "2023年11月15日",
+		"{{float_ts | date '15:04:05.000'}}":             "06:13:20.500",
+		"{{nickname | truncate 4}}":                      "一个很长…",
+		"{{nickname | truncate 4 '...'}}":                "一个很长...",
+		"{{code | truncate 10}}":                         "ab12",
+		"{{code | upper}}":                               "AB12",
+		"{{empty | default '游客'}}":                       "游客",
+		"{{missing | default 'a b' | upper}}":            "A B",
+		"{{no | pad 3}}":                                 "007",
+		"{{code | pad 6 '*'}}":                           "**ab12",
+		"编号{{no | pad 2}}，{{nickname|truncate 2|upper}}": "编号07，一个…",
+	}
+	for str, want := range list {
+		got, err := replacePlaceholders(str, variables)
+		if err != nil || got != want {
+			t.Fatalf("%s got %s %v, want %s", str, got, err, want)
+		}
+	}
+
+	bad := []string{
+		"{{price | unknown}}",
+		"{{price | }}",
+		"{{price | upper 1}}",
+		"{{price | truncate}}",
+		"{{price | truncate abc}}",
+		"{{price | pad 1000}}",
+		"{{price | pad 3 'ab'}}",
+		"{{price | default 'a}}",
+		"{{code | currency}}",
+		"{{code | date}}",
+		"{{bad_day | date}}",
+		"{{bad_ts | date}}",
+		"{{missing | upper}}",
+	}
+	for _, str := range bad {
+		if _, err := replacePlaceholders(str, variables); err == nil {
+			t.Fatalf("%s should fail", str)
+		}
+	}
+}
+
+func TestCheckFormats(t *testing.T) {
+	tpl := &Template{Name: "a", Param: json.RawMessage(`{"layers": [{"text": {"content": "{{price | currency}} {{nickname | default '游客'}}"}}]}`)}
+	if err := check(tpl); err != nil {
+		t.Fatal(err)
+	}
+	if len(tpl.Variables) != 2 || tpl.Variables[0] != "nickname" || tpl.Variables[1] != "price" {
+		t.Fatalf("variables got %v", tpl.Variables)
+	}
+	// 保存时检查格式化函数
+	tpl.Param = json.RawMessage(`{"layers": [{"text": {"content": "{{price | truncate}}"}}]}`)
+	if err := check(tpl); err == nil {
+		t.Fatal("invalid format should fail")
+	}
+}
@@ -0,0 +1,96 @@
+package template
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/shiguanghuxian/poster/program/logger"
+)
+
+// 所有模板的全部版本和latest写入存储文件，服务重启后加载 - 模板目录中的模板也保存历史版本，
+// 重启后文件内容没有变化时继续使用原版本号，变化时生成新版本，指定版本号的引用在重启后仍指向相同的内容
+
+// storeFile 存储文件内容
+type storeFile struct {
+	Templates []*storedTemplate `json:"templates"`
+}
+
+// storedTemplate 一个模板的所有版本
+type storedTemplate struct {
+	Name     string      `json:"name"`
+	Latest   int         `json:"latest"`
+	Versions []*Template `json:"versions"`
+}
+
+// LoadFile 加载存储文件中的模板，之后模板变化时写入该文件 - 文件不存在时在第一次保存时创建，需要在LoadDir之前调用
+func (s *Store) LoadFile(path string) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.path = path
+	body, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) == true {
+		return nil
+	}
+	if err != nil {
+		return
+	}
+	sf := new(storeFile)
+	if err = json.Unmarshal(body, sf); err != nil {
+		return
+	}
+	for _, st := range sf.Templates {
+		if st.valid() == false {
+			logger.Log.Errorw("模板存储文件中的模板错误", "name", st.Name, "path", path)
+			continue
+		}
+		s.templates[st.Name] = &templateVersions{versions: st.Versions, latest: st.Latest}
+	}
+	logger.Log.Infow("加载模板存储文件", "path", path, "count", len(s.templates))
+	return
+}
+
+// persist 将所有模板写入存储文件 - 调用时已加锁，未设置存储文件时不保存
+// 先写入临时文件再重命名，写入中途出错不会破坏原文件
+func (s *Store) persist() (err error) {
+	if s.path == "" {
+		return
+	}
+	sf := new(storeFile)
+	for name, tv := range s.templates {
+		sf.Templates = append(sf.Templates, &storedTemplate{Name: name, Latest: tv.latest, Versions: tv.versions})
+	}
+	sort.Slice(sf.Templates, func(i, j int) bool {
+		return sf.Templates[i].Name < sf.Templates[j].Name
+	})
+	body, err := json.MarshalIndent(sf, "", "  ")
+	if err != nil {
+		return
+	}
+	if err = os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return
+	}
+	tmp := s.path + ".tmp"
+	if err = ioutil.WriteFile(tmp, body, 0644); err != nil {
+		return
+	}
+	if err = os.Rename(tmp, s.path); err != nil {
+		logger.Log.Errorw("写入模板存储文件错误", "err", err, "path", s.path)
+	}
+	return
+}
+
+// valid 检查模板名、版本号和latest - 模板文件已删除时latest为0
+func (st *storedTemplate) valid() bool {
+	if nameRegexp.MatchString(st.Name) == false || len(st.Versions) == 0 || st.Latest < 0 || st.Latest > len(st.Versions) {
+		return false
+	}
+	for i, t := range st.Versions {
+		if t == nil || t.Name != st.Name || t.Version != i+1 {
+			return false
+		}
+	}
+	return true
+}
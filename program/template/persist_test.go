@@ -0,0 +1,183 @@
+package template
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPersist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "poster-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "data", "templates.json")
+
+	s := NewStore()
+	if err = s.LoadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	v1 := strings.Replace(testParam, "你好", "v1", 1)
+	for _, tpl := range []*Template{
+		{Name: "card", Param: json.RawMessage(v1)},
+		{Name: "card", Param: json.RawMessage(testParam)},
+		{Name: "other", Param: json.RawMessage(testParam)},
+	} {
+		if _, err = s.Save(tpl); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = s.Rollback("card", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Delete("other"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 重启后版本和latest不变
+	loaded := NewStore()
+	if err = loaded.LoadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	versions, latest, err := loaded.Versions("card")
+	if err != nil || latest != 1 || len(versions) != 2 {
+		t.Fatalf("versions got %v %d %v", versions, latest, err)
+	}
+	if _, err = loaded.Get("other"); err != ErrNotFound {
+		t.Fatalf("deleted template got %v", err)
+	}
+	saved, err := loaded.Save(&Template{Name: "card", Param: json.RawMessage(testParam)})
+	if err != nil || saved.Version != 3 {
+		t.Fatalf("save after load got %v %v", saved, err)
+	}
+
+	// 写入失败时不保存
+	if err = os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(dir, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(dir)
+	if _, err = loaded.Save(&Template{Name: "new", Param: json.RawMessage(testParam)}); err == nil {
+		t.Fatal("save should fail when the store file can not be written")
+	}
+	if _, err = loaded.Get("new"); err != ErrNotFound {
+		t.Fatalf("failed save got %v", err)
+	}
+	if _, _, err = loaded.Versions("card"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = loaded.Rollback("card", 2); err == nil {
+		t.Fatal("rollback should fail when the store file can not be written")
+	}
+	if _, latest, _ = loaded.Versions("card"); latest != 3 {
+		t.Fatalf("failed rollback latest got %d", latest)
+	}
+}
+
+func TestPersistFileTemplates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "poster-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "templates.json")
+	tplDir := filepath.Join(dir, "templates")
+	if err = os.MkdirAll(tplDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	write := func(name, content string) {
+		body := `{"param": {"width": 100, "layers": [{"text": {"width": 100, "content": "` + content + `"}}]}}`
+		if err := ioutil.WriteFile(filepath.Join(tplDir, name), []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// restart 模拟服务重启
+	restart := func() *Store {
+		s := NewStore()
+		if err := s.LoadFile(path); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.LoadDir(tplDir); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	content := func(s *Store, ref string) string {
+		tpl, err := s.Get(ref)
+		if err != nil {
+			t.Fatalf("get %s got %v", ref, err)
+		}
+		param, err := tpl.Render(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return param.Layers[0].Text.Content
+	}
+
+	// 模板文件使用通过接口创建过的模板名
+	s := NewStore()
+	if err = s.LoadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []string{"api1", "api2"} {
+		if _, err = s.Save(&Template{Name: "card", Param: json.RawMessage(`{"layers": [{"text": {"content": "` + c + `"}}]}`)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("card.json", "file1")
+	write("banner.json", "banner1")
+	if err = s.LoadDir(tplDir); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	if _, err = s.Save(&Template{Name: "other", Param: json.RawMessage(testParam)}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 文件没有变化时版本号不变，接口保存的版本保留
+	s = restart()
+	if got := content(s, "card"); got != "file1" {
+		t.Fatalf("card got %s", got)
+	}
+	if got := content(s, "card@2"); got != "api2" {
+		t.Fatalf("card@2 got %s", got)
+	}
+	if _, latest, _ := s.Versions("card"); latest != 3 {
+		t.Fatalf("card latest got %d", latest)
+	}
+	s.Close()
+
+	// 服务停止期间修改和删除文件
+	write("card.json", "file2")
+	if err = os.Remove(filepath.Join(tplDir, "banner.json")); err != nil {
+		t.Fatal(err)
+	}
+	s = restart()
+	defer s.Close()
+	if got := content(s, "card"); got != "file2" {
+		t.Fatalf("changed card got %s", got)
+	}
+	if got := content(s, "card@3"); got != "file1" {
+		t.Fatalf("card@3 got %s", got)
+	}
+	if _, err = s.Get("banner"); err != ErrNotFound {
+		t.Fatalf("removed banner got %v", err)
+	}
+	if got := content(s, "banner@1"); got != "banner1" {
+		t.Fatalf("banner@1 got %s", got)
+	}
+	// 删除后的状态也写入存储文件
+	loaded := NewStore()
+	if err = loaded.LoadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if versions, latest, err := loaded.Versions("banner"); err != nil || latest != 0 || len(versions) != 1 {
+		t.Fatalf("stored banner got %v %d %v", versions, latest, err)
+	}
+}
@@ -0,0 +1,532 @@
+// Package template 海报模板 - 在服务端保存海报参数，生成时只需要传变量
+package template
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/shiguanghuxian/poster/program/service"
+)
+
+// ErrNotFound 模板不存在
+var ErrNotFound = errors.New("Template not found")
+
+// ErrVersionNotFound 模板版本不存在
+var ErrVersionNotFound = errors.New("Template version not found")
+
+// Latest 模板引用中表示最新版本
+const Latest = "latest"
+
+// ErrReadOnly 模板来自模板目录中的文件，只能修改文件
+var ErrReadOnly = errors.New("Template is loaded from a file and can only be changed in the templates directory")
+
+// nameRegexp 模板名 - 字母、数字、下划线、中划线，最长64个字符
+var nameRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// placeholderRegexp 变量占位符 {{name}}，变量名可以包含点，如 {{user.nickname}}，变量名后可以使用格式化函数，如 {{price | currency}}
+var placeholderRegexp = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_.]*)\s*(\|[^{}]*)?\}\}`)
+
+// Template 海报模板 - Param为海报参数，字符串中可以使用{{变量名}}占位符，如文本内容、图片地址、颜色
+// 每次保存生成一个新版本，已保存的版本不会再修改
+type Template struct {
+	Name        string          `json:"name"`                  // 模板名
+	Version     int             `json:"version"`               // 版本号 - 从1开始，每次保存加1
+	Description string          `json:"description,omitempty"` // 说明
+	Param       json.RawMessage `json:"param"`                 // 海报参数 - 同/create的请求参数
+	Variables   []string        `json:"variables,omitempty"`   // 模板中使用的变量 - 保存时自动提取
+	File        string          `json:"file,omitempty"`        // 模板文件路径 - 来自模板目录时不为空
+	CreatedAt   time.Time       `json:"created_at"`            // 模板创建时间
+	UpdatedAt   time.Time       `json:"updated_at"`            // 该版本保存时间
+}
+
+// templateVersions 一个模板的所有版本
+type templateVersions struct {
+	versions []*Template // 按版本号排列
+	latest   int         // latest指向的版本号 - 回滚后可能不是最大的版本号，模板文件删除后为0
+}
+
+// get 获取指定版本
+func (tv *templateVersions) get(version int) (*Template, error) {
+	if version < 1 || version > len(tv.versions) {
+		return nil, ErrVersionNotFound
+	}
+	return tv.versions[version-1], nil
+}
+
+// current latest指向的版本 - 模板文件删除后为nil，之前的版本仍可以按版本号获取
+func (tv *templateVersions) current() *Template {
+	t, _ := tv.get(tv.latest)
+	return t
+}
+
+// file latest版本的模板文件路径 - 通过接口保存或模板文件已删除时为空
+func (tv *templateVersions) file() string {
+	if t := tv.current(); t != nil {
+		return t.File
+	}
+	return ""
+}
+
+// Store 模板存储 - 只保存在内存中，设置存储文件(LoadFile)后所有模板和版本同时写入文件
+type Store struct {
+	mu        sync.RWMutex
+	templates map[string]*templateVersions
+	watcher   *fsnotify.Watcher // 模板目录监听
+	path      string            // 存储文件路径 - 为空时重启后通过接口保存的模板和所有版本会丢失
+}
+
+// NewStore 创建模板存储
+func NewStore() *Store {
+	return &Store{templates: make(map[string]*templateVersions)}
+}
+
+// defaultStore 服务使用的模板存储
+var defaultStore = NewStore()
+
+// Default 服务使用的模板存储
+func Default() *Store {
+	return defaultStore
+}
+
+// ParseRef 解析模板引用 name、name@latest、name@3，返回模板名和版本号，latest时版本号为0
+func ParseRef(ref string) (name string, version int, err error) {
+	i := strings.LastIndex(ref, "@")
+	if i < 0 {
+		return ref, 0, nil
+	}
+	name = ref[:i]
+	if v := ref[i+1:]; v != Latest {
+		version, err = strconv.Atoi(v)
+		if err != nil || version < 1 {
+			return "", 0, fmt.Errorf("Invalid template version -- %s", v)
+		}
+	}
+	return
+}
+
+// List 模板列表 - 每个模板为latest版本，按模板名排序，不包含模板文件已删除的模板
+func (s *Store) List() []*Template {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]*Template, 0, len(s.templates))
+	for _, tv := range s.templates {
+		if t := tv.current(); t != nil {
+			list = append(list, t)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// Get 获取模板 - ref为模板名时获取latest版本，也可以是name@latest、name@版本号
+// 模板文件删除后latest返回ErrNotFound，指定版本号仍可以获取
+func (s *Store) Get(ref string) (*Template, error) {
+	name, version, err := ParseRef(ref)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	tv, ok := s.templates[name]
+	if ok == false {
+		return nil, ErrNotFound
+	}
+	if version == 0 {
+		if tv.latest == 0 {
+			return nil, ErrNotFound
+		}
+		version = tv.latest
+	}
+	return tv.get(version)
+}
+
+// Versions 模板的所有版本和latest指向的版本号 - 模板文件删除后latest为0
+func (s *Store) Versions(name string) (versions []*Template, latest int, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	tv, ok := s.templates[name]
+	if ok == false {
+		return nil, 0, ErrNotFound
+	}
+	return append([]*Template(nil), tv.versions...), tv.latest, nil
+}
+
+// Rollback 将latest指向之前的版本 - 之后保存的版本号继续递增，来自模板文件的模板不能回滚
+// 模板文件已删除时返回ErrNotFound，需要恢复模板文件或通过接口保存新版本
+func (s *Store) Rollback(name string, version int) (*Template, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tv, ok := s.templates[name]
+	if ok == false || tv.latest == 0 {
+		return nil, ErrNotFound
+	}
+	if tv.file() != "" {
+		return nil, ErrReadOnly
+	}
+	t, err := tv.get(version)
+	if err != nil {
+		return nil, err
+	}
+	prev := tv.latest
+	tv.latest = version
+	if err = s.persist(); err != nil {
+		tv.latest = prev
+		return nil, err
+	}
+	return t, nil
+}
+
+// Save 创建或更新模板，生成一个新版本 - 检查参数并提取变量，不能覆盖来自模板文件的模板
+func (s *Store) Save(t *Template) (saved *Template, err error) {
+	if t != nil {
+		t.File = ""
+	}
+	return s.save(t)
+}
+
+// save 创建或更新模板 - 模板文件内容没有变化时不生成新版本
+func (s *Store) save(t *Template) (saved *Template, err error) {
+	if err = check(t); err != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	saved = &Template{
+		Name:        t.Name,
+		Version:     1,
+		Description: t.Description,
+		Param:       t.Param,
+		Variables:   t.Variables,
+		File:        t.File,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	tv, ok := s.templates[t.Name]
+	if ok == false {
+		tv = new(templateVersions)
+	} else {
+		if tv.file() != "" && t.File == "" {
+			return nil, ErrReadOnly
+		}
+		latest := tv.current()
+		if t.File != "" && latest != nil && latest.File == t.File && latest.Description == t.Description && sameJSON(latest.Param, t.Param) == true {
+			return latest, nil
+		}
+		saved.Version = len(tv.versions) + 1
+		saved.CreatedAt = tv.versions[0].CreatedAt
+	}
+	prev := tv.latest
+	tv.versions = append(tv.versions, saved)
+	tv.latest = saved.Version
+	s.templates[t.Name] = tv
+	// 写入存储文件失败时撤销
+	if err = s.persist(); err != nil {
+		tv.versions = tv.versions[:len(tv.versions)-1]
+		tv.latest = prev
+		if len(tv.versions) == 0 {
+			delete(s.templates, t.Name)
+		}
+		return nil, err
+	}
+	return
+}
+
+// sameJSON 两个json除空白外是否相同 - 存储文件中的参数会重新缩进
+func sameJSON(a, b json.RawMessage) bool {
+	var ca, cb bytes.Buffer
+	if json.Compact(&ca, a) != nil || json.Compact(&cb, b) != nil {
+		return bytes.Equal(a, b)
+	}
+	return bytes.Equal(ca.Bytes(), cb.Bytes())
+}
+
+// Delete 删除模板的所有版本
+func (s *Store) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tv, ok := s.templates[name]
+	if ok == false {
+		return ErrNotFound
+	}
+	if tv.file() != "" {
+		return ErrReadOnly
+	}
+	delete(s.templates, name)
+	if err := s.persist(); err != nil {
+		s.templates[name] = tv
+		return err
+	}
+	return nil
+}
+
+// Render 使用变量替换模板中的占位符，返回海报参数 - ref同Get
+func (s *Store) Render(ref string, variables map[string]interface{}) (*service.PosterParam, error) {
+	t, err := s.Get(ref)
+	if err != nil {
+		return nil, err
+	}
+	return t.Render(variables)
+}
+
+// Render 处理图层指令并使用变量替换模板中的占位符，返回海报参数 - 缺少变量时报错
+// 变量值为字符串、数字、布尔值，repeat使用的变量为列表，{{a.b}}可以取对象中的字段
+func (t *Template) Render(variables map[string]interface{}) (param *service.PosterParam, err error) {
+	v, err := decodeParam(t.Param)
+	if err != nil {
+		return
+	}
+	v, err = render(v, variables)
+	if err != nil {
+		return
+	}
+	return toPosterParam(v)
+}
+
+// check 检查模板名和参数
+func check(t *Template) (err error) {
+	if t == nil {
+		return errors.New("The template cannot be nil")
+	}
+	if nameRegexp.MatchString(t.Name) == false {
+		return fmt.Errorf("Invalid template name -- %s", t.Name)
+	}
+	v, err := decodeParam(t.Param)
+	if err != nil {
+		return
+	}
+	if _, ok := v.(map[string]interface{}); ok == false {
+		return errors.New("The template param must be a JSON object")
+	}
+	stripped, names, err := stripDirectives(v)
+	if err != nil {
+		return
+	}
+	t.Variables = names
+	// 变量都为空时格式化函数要正确
+	empty := make(map[string]interface{})
+	for _, name := range placeholderNames(stripped) {
+		empty[name] = ""
+	}
+	if _, err = substitute(stripped, empty); err != nil {
+		return
+	}
+	// 占位符替换为示例值后按生成海报时的规则检查参数，模板文件修改错误时继续使用上一个版本
+	param, err := toPosterParam(sampleParam(stripped, ""))
+	if err != nil {
+		return
+	}
+	_, err = service.NewService(param)
+	return
+}
+
+// requiredKeys 不能为空的字符串参数 - 包含占位符时用非空的示例值检查
+var requiredKeys = map[string]bool{"content": true, "image_url": true, "access_token": true}
+
+// sampleParam 将占位符替换为示例值 - 颜色为#000000，必填参数为x，其他为空字符串（按默认值检查）
+func sampleParam(v interface{}, key string) interface{} {
+	switch val := v.(type) {
+	case string:
+		if placeholderRegexp.MatchString(val) == false {
+			return val
+		}
+		if strings.HasSuffix(key, "color") == true {
+			return "#000000"
+		}
+		sample := ""
+		if requiredKeys[key] == true {
+			sample = "x"
+		}
+		return placeholderRegexp.ReplaceAllLiteralString(val, sample)
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			m[k] = sampleParam(item, k)
+		}
+		return m
+	case []interface{}:
+		list := make([]interface{}, 0, len(val))
+		for _, item := range val {
+			list = append(list, sampleParam(item, key))
+		}
+		return list
+	}
+	return v
+}
+
+// decodeParam 解析模板参数 - 数字保持原样
+func decodeParam(raw json.RawMessage) (v interface{}, err error) {
+	if len(raw) == 0 {
+		return nil, errors.New("The template param cannot be empty")
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err = dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("Invalid template param: %v", err)
+	}
+	return
+}
+
+// toPosterParam 转为海报参数
+func toPosterParam(v interface{}) (param *service.PosterParam, err error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	param = new(service.PosterParam)
+	// 拼错的参数名报错，不静默忽略
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	if err = dec.Decode(param); err != nil {
+		return nil, fmt.Errorf("Invalid template param: %v", err)
+	}
+	return
+}
+
+// substitute 替换所有字符串中的占位符，返回新的值
+func substitute(v interface{}, variables map[string]interface{}) (interface{}, error) {
+	switch val := v.(type) {
+	case string:
+		return replacePlaceholders(val, variables)
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			replaced, err := substitute(item, variables)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = replaced
+		}
+		return m, nil
+	case []interface{}:
+		list := make([]interface{}, 0, len(val))
+		for _, item := range val {
+			replaced, err := substitute(item, variables)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, replaced)
+		}
+		return list, nil
+	}
+	return v, nil
+}
+
+// replacePlaceholders 替换字符串中的占位符
+func replacePlaceholders(str string, variables map[string]interface{}) (string, error) {
+	var err error
+	replaced := placeholderRegexp.ReplaceAllStringFunc(str, func(match string) string {
+		m := placeholderRegexp.FindStringSubmatch(match)
+		value, e := placeholderValue(m[1], m[2], variables)
+		if e != nil {
+			if err == nil {
+				err = e
+			}
+			return match
+		}
+		return value
+	})
+	return replaced, err
+}
+
+// placeholderValue 占位符的值 - 查找变量并执行格式化函数，使用default时变量可以不存在
+func placeholderValue(name, expr string, variables map[string]interface{}) (value string, err error) {
+	formats, err := parseFormats(expr)
+	if err != nil {
+		return "", fmt.Errorf("Invalid placeholder {{%s%s}}: %v", name, expr, err)
+	}
+	v, ok := lookup(variables, name)
+	if ok == true {
+		value, err = variableString(name, v)
+		if err != nil {
+			return
+		}
+	} else if hasDefault(formats) == false {
+		return "", fmt.Errorf("Missing template variable -- %s", name)
+	}
+	value, err = applyFormats(value, formats)
+	if err != nil {
+		return "", fmt.Errorf("Template variable %s: %v", name, err)
+	}
+	return
+}
+
+// lookup 查找变量 - 先按完整变量名查找，找不到时 a.b 查找变量a中的字段b，值为null时视为不存在
+func lookup(variables map[string]interface{}, name string) (v interface{}, ok bool) {
+	if v, ok = variables[name]; ok == true {
+		return v, v != nil
+	}
+	parts := strings.Split(name, ".")
+	if len(parts) == 1 {
+		return nil, false
+	}
+	v, ok = variables[parts[0]]
+	for _, part := range parts[1:] {
+		m, isMap := v.(map[string]interface{})
+		if ok == false || isMap == false {
+			return nil, false
+		}
+		v, ok = m[part]
+	}
+	return v, ok == true && v != nil
+}
+
+// variableString 变量转为字符串 - 列表和对象不能直接替换到字符串中
+func variableString(name string, v interface{}) (string, error) {
+	switch val := v.(type) {
+	case string:
+		return val, nil
+	case []interface{}, map[string]interface{}:
+		return "", fmt.Errorf("The template variable %s must be a string, number or bool", name)
+	}
+	return fmt.Sprint(v), nil
+}
+
+// placeholderNames 参数中所有占位符的变量名，按名称排序
+func placeholderNames(v interface{}) []string {
+	var names []string
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch val := v.(type) {
+		case string:
+			for _, m := range placeholderRegexp.FindAllStringSubmatch(val, -1) {
+				names = append(names, m[1])
+			}
+		case map[string]interface{}:
+			for _, item := range val {
+				walk(item)
+			}
+		case []interface{}:
+			for _, item := range val {
+				walk(item)
+			}
+		}
+	}
+	walk(v)
+	return uniqueSorted(names)
+}
+
+// uniqueSorted 去重并排序
+func uniqueSorted(names []string) []string {
+	seen := make(map[string]bool, len(names))
+	list := make([]string, 0, len(names))
+	for _, name := range names {
+		if seen[name] == false {
+			seen[name] = true
+			list = append(list, name)
+		}
+	}
+	sort.Strings(list)
+	return list
+}
@@ -0,0 +1,177 @@
+package template
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const testParam = `{
+	"width": 400,
+	"height": 300,
+	"background": {"color": "{{bg_color}}"},
+	"layers": [
+		{"text": {"width": 300, "font_size": 20, "content": "你好，{{ nickname }}！价格 {{price}}"}},
+		{"qr_code": {"width": 100, "content": "{{qr_url}}"}}
+	]
+}`
+
+func TestStore(t *testing.T) {
+	s := NewStore()
+	saved, err := s.Save(&Template{Name: "share_card", Description: "分享卡片", Param: json.RawMessage(testParam)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"bg_color", "nickname", "price", "qr_url"}
+	if len(saved.Variables) != len(want) {
+		t.Fatalf("variables got %v", saved.Variables)
+	}
+	for i := range want {
+		if saved.Variables[i] != want[i] {
+			t.Fatalf("variables got %v", saved.Variables)
+		}
+	}
+	// 更新时保留创建时间
+	updated, err := s.Save(&Template{Name: "share_card", Param: json.RawMessage(testParam)})
+	if err != nil || updated.CreatedAt != saved.CreatedAt {
+		t.Fatalf("update got %v %v", updated, err)
+	}
+	if list := s.List(); len(list) != 1 || list[0].Name != "share_card" {
+		t.Fatalf("list got %v", list)
+	}
+
+	// 变量中的引号等字符不会破坏json
+	param, err := s.Render("share_card", map[string]interface{}{
+		"bg_color": "#FFEEDD",
+		"nickname": `小"明"\\`,
+		"price":    "99",
+		"qr_url":   "https://example.com/?a=1&b=2",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if param.Width != 400 || param.Background.Color != "#FFEEDD" {
+		t.Fatalf("param got %+v", param)
+	}
+	if got := param.Layers[0].Text.Content; got != `你好，小"明"\\！价格 99` {
+		t.Fatalf("content got %s", got)
+	}
+	if got := param.Layers[1].QrCode.Content; got != "https://example.com/?a=1&b=2" {
+		t.Fatalf("qr content got %s", got)
+	}
+	// 渲染不修改模板
+	if param, err = s.Render("share_card", map[string]interface{}{"bg_color": "", "nickname": "a", "price": "1", "qr_url": "b"}); err != nil || param.Layers[0].Text.Content != "你好，a！价格 1" {
+		t.Fatalf("second render got %v", err)
+	}
+
+	if _, err = s.Render("share_card", map[string]interface{}{"nickname": "a"}); err == nil {
+		t.Fatal("missing variable should fail")
+	}
+	if _, err = s.Render("none", nil); err != ErrNotFound {
+		t.Fatalf("not found got %v", err)
+	}
+	if err = s.Delete("share_card"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Delete("share_card"); err != ErrNotFound {
+		t.Fatalf("delete twice got %v", err)
+	}
+}
+
+func TestVersions(t *testing.T) {
+	s := NewStore()
+	v1 := strings.Replace(testParam, "你好", "v1", 1)
+	v2 := strings.Replace(testParam, "你好", "v2", 1)
+	for _, param := range []string{v1, v2} {
+		if _, err := s.Save(&Template{Name: "card", Param: json.RawMessage(param)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	vars := map[string]interface{}{"bg_color": "", "nickname": "a", "price": "1", "qr_url": "b"}
+	content := func(ref string) string {
+		param, err := s.Render(ref, vars)
+		if err != nil {
+			t.Fatalf("render %s got %v", ref, err)
+		}
+		return param.Layers[0].Text.Content
+	}
+	if got := content("card"); got != "v2，a！价格 1" {
+		t.Fatalf("latest got %s", got)
+	}
+	if got := content("card@1"); got != "v1，a！价格 1" {
+		t.Fatalf("pinned got %s", got)
+	}
+	if got := content("card@latest"); got != "v2，a！价格 1" {
+		t.Fatalf("card@latest got %s", got)
+	}
+	for ref, want := range map[string]error{"card@3": ErrVersionNotFound, "none@1": ErrNotFound} {
+		if _, err := s.Get(ref); err != want {
+			t.Fatalf("get %s got %v", ref, err)
+		}
+	}
+	if _, err := s.Get("card@v1"); err == nil {
+		t.Fatal("invalid version should fail")
+	}
+
+	// 回滚后latest指向旧版本，再保存版本号继续递增
+	if _, err := s.Rollback("card", 1); err != nil {
+		t.Fatal(err)
+	}
+	if got := content("card"); got != "v1，a！价格 1" {
+		t.Fatalf("rollback got %s", got)
+	}
+	if list := s.List(); len(list) != 1 || list[0].Version != 1 {
+		t.Fatalf("list got %v", list)
+	}
+	if _, err := s.Rollback("card", 5); err != ErrVersionNotFound {
+		t.Fatalf("rollback missing version got %v", err)
+	}
+	saved, err := s.Save(&Template{Name: "card", Param: json.RawMessage(v2)})
+	if err != nil || saved.Version != 3 {
+		t.Fatalf("save after rollback got %v %v", saved, err)
+	}
+	versions, latest, err := s.Versions("card")
+	if err != nil || latest != 3 || len(versions) != 3 {
+		t.Fatalf("versions got %v %d %v", versions, latest, err)
+	}
+	for i, v := range versions {
+		if v.Version != i+1 {
+			t.Fatalf("version %d got %d", i+1, v.Version)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	cases := []*Template{
+		{Name: "", Param: json.RawMessage(`{}`)},
+		{Name: "../a", Param: json.RawMessage(`{}`)},
+		{Name: "a", Param: nil},
+		{Name: "a", Param: json.RawMessage(`[1, 2]`)},
+		{Name: "a", Param: json.RawMessage(`{"width": "{{w}}"}`)},
+		{Name: "a", Param: json.RawMessage(`{"width": `)},
+		// 和生成海报时相同的参数检查
+		{Name: "a", Param: json.RawMessage(`{"layers": [{"type": "video", "text": {"content": "a"}}]}`)},
+		{Name: "a", Param: json.RawMessage(`{"layers": [{"text": {"content": "a"}, "qr_code": {"content": "b"}}]}`)},
+		{Name: "a", Param: json.RawMessage(`{"layers": [{"text": {"content": "a", "font_color": "#GG0000"}}]}`)},
+		{Name: "a", Param: json.RawMessage(`{"layers": [{"shape": {"type": "rect", "width": 10, "height": 10, "fill_color": "red"}}]}`)},
+		{Name: "a", Param: json.RawMessage(`{"layers": [{"image": {"width": -10, "image_url": "{{url}}"}}]}`)},
+		{Name: "a", Param: json.RawMessage(`{"layers": [{"text": {"content": "a", "anchor": "middle"}}]}`)},
+		{Name: "a", Param: json.RawMessage(`{"output": {"format": "gif"}}`)},
+		{Name: "a", Param: json.RawMessage(`{"scale": 10}`)},
+		{Name: "a", Param: json.RawMessage(`{"layers": [{"text": {"content": "a", "font_colour": "#FF0000"}}]}`)},
+		{Name: "a", Param: json.RawMessage(`{"backgroud": {"color": "#FFFFFF"}}`)},
+	}
+	for _, c := range cases {
+		if _, err := NewStore().Save(c); err == nil {
+			t.Fatalf("template %s %s should be invalid", c.Name, c.Param)
+		}
+	}
+	// 必填参数和颜色使用占位符时可以保存
+	valid := `{"background": {"color": "{{bg}}", "image_url": "{{bg_url}}", "fit": "{{fit}}"}, "layers": [
+		{"text": {"content": "{{title}}", "font_color": "{{color}}"}},
+		{"wx_qr_code": {"access_token": "{{token}}", "scene": "{{scene}}"}}
+	]}`
+	if _, err := NewStore().Save(&Template{Name: "a", Param: json.RawMessage(valid)}); err != nil {
+		t.Fatal(err)
+	}
+}
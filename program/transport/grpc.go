@@ -2,11 +2,15 @@ package transport
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net"
+	"strings"
 
+	"github.com/golang/protobuf/ptypes/wrappers"
 	"github.com/shiguanghuxian/poster/program/config"
 	"github.com/shiguanghuxian/poster/program/service"
+	"github.com/shiguanghuxian/poster/program/template"
 	"github.com/shiguanghuxian/poster/proto"
 	grpc "google.golang.org/grpc"
 )
@@ -46,105 +50,493 @@ type PosterServer struct {
 
 // CreatePoster 创建海报
 func (ps *PosterServer) CreatePoster(ctx context.Context, req *proto.CreatePosterRequest) (rsp *proto.CreatePosterReply, err error) {
-	rsp = new(proto.CreatePosterReply)
 	// 海报生成对象
 	param := &service.PosterParam{
-		Width:  int(req.Width),
-		Height: int(req.Height),
+		Width:      int(req.Width),
+		Height:     int(req.Height),
+		LayoutMode: int(req.LayoutMode),
+		Scale:      req.Scale,
+		Scales:     req.Scales,
 	}
 	// 背景
 	if req.Background != nil {
 		param.Background = &service.Background{
 			Image:    req.Background.Image,
 			ImageURL: req.Background.ImageUrl,
+			Color:    req.Background.Color,
+			Gradient: gradientFromProto(req.Background.Gradient),
+
+			Fit:        req.Background.Fit,
+			FocalPoint: pointFromProto(req.Background.FocalPoint),
 		}
 	}
-	// 文本
-	if len(req.Texts) > 0 {
-		param.Texts = make([]*service.Text, 0)
-		for _, v := range req.Texts {
-			param.Texts = append(param.Texts, &service.Text{
-				SubObject: service.SubObject{
-					Top:    int(v.Top),
-					Left:   int(v.Left),
-					Width:  int(v.Width),
-					Height: int(v.Height),
-				},
-				LineCount:  int(v.LineCount),
-				Content:    v.Content,
-				FontName:   v.FontName,
-				FontSize:   v.FontSize,
-				LineHeight: v.LineHeight,
-				FontColor:  v.FontColor,
-			})
+	// 输出图片参数
+	if req.Output != nil {
+		param.Output = &service.Output{
+			Format:           req.Output.Format,
+			Quality:          int(req.Output.Quality),
+			Progressive:      req.Output.Progressive,
+			CompressionLevel: int(req.Output.CompressionLevel),
 		}
 	}
+	// 文本
+	for _, v := range req.Texts {
+		param.Texts = append(param.Texts, textFromProto(v))
+	}
 	// 子图片
-	if len(req.SubImages) > 0 {
-		param.SubImages = make([]*service.Image, 0)
-		for _, v := range req.SubImages {
-			param.SubImages = append(param.SubImages, &service.Image{
-				SubObject: service.SubObject{
-					Top:    int(v.Top),
-					Left:   int(v.Left),
-					Width:  int(v.Width),
-					Height: int(v.Height),
-				},
-				Padding:   int(v.Padding),
-				Angle:     v.Angle,
-				Color:     v.Color,
-				ImageType: v.ImageType,
-				Image:     v.Image,
-				ImageURL:  v.ImageUrl,
-			})
-		}
+	for _, v := range req.SubImages {
+		param.SubImages = append(param.SubImages, imageFromProto(v))
 	}
 	// 二维码
-	if len(req.SubQrCode) > 0 {
-		for _, v := range req.SubQrCode {
-			param.SubQrCode = append(param.SubQrCode, &service.QrCode{
-				SubObject: service.SubObject{
-					Top:   int(v.Top),
-					Left:  int(v.Left),
-					Width: int(v.Width),
-				},
-				Angle:           v.Angle,
-				BackgroundColor: v.BackgroundColor,
-				ForegroundColor: v.ForegroundColor,
-				Content:         v.Content,
-			})
-		}
+	for _, v := range req.SubQrCode {
+		param.SubQrCode = append(param.SubQrCode, qrCodeFromProto(v))
 	}
 	// 小程序码
-	if len(req.SubWxQrCode) > 0 {
-		for _, v := range req.SubWxQrCode {
-			param.SubWxQrCode = append(param.SubWxQrCode, &service.WxQrCode{
-				SubObject: service.SubObject{
-					Top:   int(v.Top),
-					Left:  int(v.Left),
-					Width: int(v.Width),
-				},
-				Angle:       v.Angle,
-				AccessToken: v.AccessToken,
-				Scene:       v.Scene,
-				Page:        v.Page,
-				AutoColor:   v.AutoColor,
-				LineColor:   v.LineColor,
-				IsHyaline:   v.IsHyaline,
-			})
-		}
+	for _, v := range req.SubWxQrCode {
+		param.SubWxQrCode = append(param.SubWxQrCode, wxQrCodeFromProto(v))
+	}
+	// 图层
+	for _, v := range req.Layers {
+		param.Layers = append(param.Layers, layerFromProto(v))
 	}
 
-	// 生成图片
+	return drawPoster(param)
+}
+
+// drawPoster 生成海报 - image为第一张图片，设置scales时images为每个倍数的图片
+func drawPoster(param *service.PosterParam) (rsp *proto.CreatePosterReply, err error) {
 	srv, err := service.NewService(param)
 	if err != nil {
 		return
 	}
-	img, err := srv.DrawPoster()
+	images, err := srv.DrawPosters()
 	if err != nil {
 		return
 	}
 	// 响应图片字节
-	rsp.Image = img
+	rsp = new(proto.CreatePosterReply)
+	rsp.Image = images[0].Image
+	if len(param.Scales) > 0 {
+		for _, v := range images {
+			rsp.Images = append(rsp.Images, &proto.ScaledImage{
+				Scale:  v.Scale,
+				Width:  int32(v.Width),
+				Height: int32(v.Height),
+				Image:  v.Image,
+			})
+		}
+	}
+	rsp.Overflow = srv.Overflow
+	rsp.MimeType = srv.Param.Output.MimeType()
+	return
+}
+
+// ListFonts 获取可用字体列表
+func (ps *PosterServer) ListFonts(ctx context.Context, req *proto.ListFontsRequest) (rsp *proto.ListFontsReply, err error) {
+	rsp = new(proto.ListFontsReply)
+	for _, v := range service.ListFonts() {
+		rsp.Fonts = append(rsp.Fonts, &proto.Font{
+			Name:   v.Name,
+			Family: v.Family,
+			Style:  v.Style,
+		})
+	}
+	return
+}
+
+// ListTemplates 模板列表
+func (ps *PosterServer) ListTemplates(ctx context.Context, req *proto.ListTemplatesRequest) (rsp *proto.ListTemplatesReply, err error) {
+	rsp = new(proto.ListTemplatesReply)
+	for _, v := range template.Default().List() {
+		rsp.Templates = append(rsp.Templates, templateToProto(v))
+	}
+	return
+}
+
+// GetTemplate 获取模板 - 模板名可以是 name@版本号、name@latest
+func (ps *PosterServer) GetTemplate(ctx context.Context, req *proto.GetTemplateRequest) (rsp *proto.Template, err error) {
+	t, err := template.Default().Get(req.Name)
+	if err != nil {
+		return
+	}
+	return templateToProto(t), nil
+}
+
+// SaveTemplate 创建或更新模板
+func (ps *PosterServer) SaveTemplate(ctx context.Context, req *proto.Template) (rsp *proto.Template, err error) {
+	t, err := template.Default().Save(&template.Template{
+		Name:        req.Name,
+		Description: req.Description,
+		Param:       json.RawMessage(req.Param),
+	})
+	if err != nil {
+		return
+	}
+	return templateToProto(t), nil
+}
+
+// DeleteTemplate 删除模板
+func (ps *PosterServer) DeleteTemplate(ctx context.Context, req *proto.DeleteTemplateRequest) (rsp *proto.DeleteTemplateReply, err error) {
+	err = template.Default().Delete(req.Name)
+	if err != nil {
+		return
+	}
+	return new(proto.DeleteTemplateReply), nil
+}
+
+// ListTemplateVersions 模板的所有版本
+func (ps *PosterServer) ListTemplateVersions(ctx context.Context, req *proto.ListTemplateVersionsRequest) (rsp *proto.ListTemplateVersionsReply, err error) {
+	versions, latest, err := template.Default().Versions(req.Name)
+	if err != nil {
+		return
+	}
+	rsp = &proto.ListTemplateVersionsReply{
+		Latest: int32(latest),
+	}
+	for _, v := range versions {
+		rsp.Versions = append(rsp.Versions, templateToProto(v))
+	}
 	return
 }
+
+// RollbackTemplate 将模板latest回滚到之前的版本
+func (ps *PosterServer) RollbackTemplate(ctx context.Context, req *proto.RollbackTemplateRequest) (rsp *proto.Template, err error) {
+	t, err := template.Default().Rollback(req.Name, int(req.Version))
+	if err != nil {
+		return
+	}
+	return templateToProto(t), nil
+}
+
+// RenderTemplate 使用模板生成海报 - 模板名可以是 name@版本号、name@latest
+func (ps *PosterServer) RenderTemplate(ctx context.Context, req *proto.RenderTemplateRequest) (rsp *proto.CreatePosterReply, err error) {
+	variables := make(map[string]interface{}, len(req.Variables))
+	// json格式的变量，可以包含列表和对象
+	if req.VariablesJson != "" {
+		dec := json.NewDecoder(strings.NewReader(req.VariablesJson))
+		dec.UseNumber()
+		if err = dec.Decode(&variables); err != nil {
+			return nil, fmt.Errorf("Invalid variables_json: %v", err)
+		}
+	}
+	for k, v := range req.Variables {
+		variables[k] = v
+	}
+	param, err := template.Default().Render(req.Name, variables)
+	if err != nil {
+		return
+	}
+	return drawPoster(param)
+}
+
+// 模板转换
+func templateToProto(t *template.Template) *proto.Template {
+	return &proto.Template{
+		Name:        t.Name,
+		Description: t.Description,
+		Param:       string(t.Param),
+		Variables:   t.Variables,
+		Version:     int32(t.Version),
+		CreatedAt:   t.CreatedAt.Unix(),
+		UpdatedAt:   t.UpdatedAt.Unix(),
+	}
+}
+
+// 图层参数转换
+func layerFromProto(v *proto.Layer) *service.Layer {
+	if v == nil {
+		return nil
+	}
+	return &service.Layer{
+		Type:     v.Type,
+		ZIndex:   int(v.ZIndex),
+		Text:     textFromProto(v.Text),
+		Image:    imageFromProto(v.Image),
+		QrCode:   qrCodeFromProto(v.QrCode),
+		WxQrCode: wxQrCodeFromProto(v.WxQrCode),
+		Shape:    shapeFromProto(v.Shape),
+	}
+}
+
+// 不透明度转换 - 不传时为nil，表示不透明
+func opacityFromProto(v *wrappers.DoubleValue) *float64 {
+	if v == nil {
+		return nil
+	}
+	opacity := v.Value
+	return &opacity
+}
+
+// 矢量图形参数转换
+func shapeFromProto(v *proto.Shape) *service.Shape {
+	if v == nil {
+		return nil
+	}
+	shape := &service.Shape{
+		SubObject: service.SubObject{
+			Top:     int(v.Top),
+			Left:    int(v.Left),
+			Width:   int(v.Width),
+			Height:  int(v.Height),
+			Opacity: opacityFromProto(v.Opacity),
+			Anchor:  v.Anchor,
+			Mask:    maskFromProto(v.Mask),
+		},
+		Type:        v.Type,
+		Radius:      service.Radius(v.Radius),
+		FillColor:   v.FillColor,
+		StrokeColor: v.StrokeColor,
+		StrokeWidth: v.StrokeWidth,
+		Dash:        v.Dash,
+		Gradient:    gradientFromProto(v.Gradient),
+	}
+	for _, p := range v.Points {
+		if p == nil {
+			continue
+		}
+		shape.Points = append(shape.Points, &service.Point{X: p.X, Y: p.Y})
+	}
+	return shape
+}
+
+// 文本参数转换
+func textFromProto(v *proto.Text) *service.Text {
+	if v == nil {
+		return nil
+	}
+	return &service.Text{
+		SubObject: service.SubObject{
+			Top:     int(v.Top),
+			Left:    int(v.Left),
+			Width:   int(v.Width),
+			Height:  int(v.Height),
+			Opacity: opacityFromProto(v.Opacity),
+			Anchor:  v.Anchor,
+			Mask:    maskFromProto(v.Mask),
+		},
+		LineCount:  int(v.LineCount),
+		Content:    v.Content,
+		FontName:   v.FontName,
+		FontSize:   v.FontSize,
+		LineHeight: v.LineHeight,
+		FontColor:  v.FontColor,
+
+		FallbackFonts: v.FallbackFonts,
+
+		Spans:         textSpansFromProto(v.Spans),
+		Bold:          v.Bold,
+		Underline:     v.Underline,
+		Strikethrough: v.Strikethrough,
+		LetterSpacing: v.LetterSpacing,
+
+		Align:         v.Align,
+		VerticalAlign: v.VerticalAlign,
+		Overflow:      v.Overflow,
+		MinFontSize:   v.MinFontSize,
+
+		Stroke:     textStrokeFromProto(v.Stroke),
+		Shadow:     textShadowFromProto(v.Shadow),
+		Background: textBackgroundFromProto(v.Background),
+		Fill:       textFillFromProto(v.Fill),
+	}
+}
+
+// 文字片段参数转换
+func textSpansFromProto(list []*proto.TextSpan) []*service.TextSpan {
+	if len(list) == 0 {
+		return nil
+	}
+	spans := make([]*service.TextSpan, 0, len(list))
+	for _, v := range list {
+		if v == nil {
+			continue
+		}
+		spans = append(spans, &service.TextSpan{
+			Content:       v.Content,
+			FontName:      v.FontName,
+			FontSize:      v.FontSize,
+			FontColor:     v.FontColor,
+			Bold:          v.Bold,
+			Underline:     v.Underline,
+			Strikethrough: v.Strikethrough,
+			LetterSpacing: v.LetterSpacing,
+		})
+	}
+	return spans
+}
+
+// 文字填充参数转换
+func textFillFromProto(v *proto.TextFill) *service.TextFill {
+	if v == nil {
+		return nil
+	}
+	return &service.TextFill{
+		Image:    v.Image,
+		ImageURL: v.ImageUrl,
+		Gradient: gradientFromProto(v.Gradient),
+	}
+}
+
+// 渐变参数转换
+func gradientFromProto(v *proto.Gradient) *service.Gradient {
+	if v == nil {
+		return nil
+	}
+	gradient := &service.Gradient{
+		Type:   v.Type,
+		Angle:  v.Angle,
+		Radius: v.Radius,
+	}
+	gradient.Center = pointFromProto(v.Center)
+	for _, stop := range v.Stops {
+		if stop == nil {
+			continue
+		}
+		gradient.Stops = append(gradient.Stops, &service.ColorStop{Offset: stop.Offset, Color: stop.Color})
+	}
+	return gradient
+}
+
+// 文字描边参数转换
+func textStrokeFromProto(v *proto.TextStroke) *service.TextStroke {
+	if v == nil {
+		return nil
+	}
+	return &service.TextStroke{
+		Color: v.Color,
+		Width: v.Width,
+	}
+}
+
+// 文字阴影参数转换
+func textShadowFromProto(v *proto.TextShadow) *service.TextShadow {
+	if v == nil {
+		return nil
+	}
+	return &service.TextShadow{
+		OffsetX: int(v.OffsetX),
+		OffsetY: int(v.OffsetY),
+		Blur:    int(v.Blur),
+		Color:   v.Color,
+	}
+}
+
+// 文字背景框参数转换
+func textBackgroundFromProto(v *proto.TextBackground) *service.TextBackground {
+	if v == nil {
+		return nil
+	}
+	return &service.TextBackground{
+		Color:   v.Color,
+		Padding: int(v.Padding),
+		Radius:  v.Radius,
+		Mode:    v.Mode,
+	}
+}
+
+// 子图片参数转换
+func imageFromProto(v *proto.Image) *service.Image {
+	if v == nil {
+		return nil
+	}
+	return &service.Image{
+		SubObject: service.SubObject{
+			Top:     int(v.Top),
+			Left:    int(v.Left),
+			Width:   int(v.Width),
+			Height:  int(v.Height),
+			Opacity: opacityFromProto(v.Opacity),
+			Anchor:  v.Anchor,
+			Mask:    maskFromProto(v.Mask),
+		},
+		Padding:   int(v.Padding),
+		Angle:     v.Angle,
+		Color:     v.Color,
+		ImageType: v.ImageType,
+		Image:     v.Image,
+		ImageURL:  v.ImageUrl,
+
+		Fit:        v.Fit,
+		FocalPoint: pointFromProto(v.FocalPoint),
+
+		Shape:        v.Shape,
+		BorderRadius: service.Radius(v.BorderRadius),
+		Border:       borderFromProto(v.Border),
+	}
+}
+
+// 坐标点参数转换
+func pointFromProto(v *proto.Point) *service.Point {
+	if v == nil {
+		return nil
+	}
+	return &service.Point{X: v.X, Y: v.Y}
+}
+
+// 蒙版参数转换
+func maskFromProto(v *proto.Mask) *service.Mask {
+	if v == nil {
+		return nil
+	}
+	return &service.Mask{
+		Image:    v.Image,
+		ImageURL: v.ImageUrl,
+		Mode:     v.Mode,
+	}
+}
+
+// 边框参数转换
+func borderFromProto(v *proto.Border) *service.Border {
+	if v == nil {
+		return nil
+	}
+	return &service.Border{
+		Color: v.Color,
+		Width: v.Width,
+	}
+}
+
+// 二维码参数转换
+func qrCodeFromProto(v *proto.QrCode) *service.QrCode {
+	if v == nil {
+		return nil
+	}
+	return &service.QrCode{
+		SubObject: service.SubObject{
+			Top:     int(v.Top),
+			Left:    int(v.Left),
+			Width:   int(v.Width),
+			Opacity: opacityFromProto(v.Opacity),
+			Anchor:  v.Anchor,
+			Mask:    maskFromProto(v.Mask),
+		},
+		Angle:           v.Angle,
+		BackgroundColor: v.BackgroundColor,
+		ForegroundColor: v.ForegroundColor,
+		Content:         v.Content,
+	}
+}
+
+// 小程序码参数转换
+func wxQrCodeFromProto(v *proto.WxQrCode) *service.WxQrCode {
+	if v == nil {
+		return nil
+	}
+	return &service.WxQrCode{
+		SubObject: service.SubObject{
+			Top:     int(v.Top),
+			Left:    int(v.Left),
+			Width:   int(v.Width),
+			Opacity: opacityFromProto(v.Opacity),
+			Anchor:  v.Anchor,
+			Mask:    maskFromProto(v.Mask),
+		},
+		Angle:       v.Angle,
+		AccessToken: v.AccessToken,
+		Scene:       v.Scene,
+		Page:        v.Page,
+		AutoColor:   v.AutoColor,
+		LineColor:   v.LineColor,
+		IsHyaline:   v.IsHyaline,
+	}
+}
@@ -1,13 +1,16 @@
 package transport
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	gin "github.com/gin-gonic/gin"
 	"github.com/shiguanghuxian/poster/program/config"
 	"github.com/shiguanghuxian/poster/program/service"
+	"github.com/shiguanghuxian/poster/program/template"
 )
 
 // HTTPTransport 提供http服务生成海报
@@ -52,6 +55,17 @@ func (s *HTTPTransport) ListenAndServe(debug bool) (err error) {
 	}
 	// 生成海报api
 	router.POST("/create", s.createPoster)
+	// 可用字体列表
+	router.GET("/fonts", s.listFonts)
+	// 模板管理
+	router.GET("/templates", s.listTemplates)
+	router.GET("/templates/:name", s.getTemplate)
+	router.PUT("/templates/:name", s.saveTemplate)
+	router.DELETE("/templates/:name", s.deleteTemplate)
+	router.GET("/templates/:name/versions", s.listTemplateVersions)
+	router.POST("/templates/:name/rollback", s.rollbackTemplate)
+	// 使用模板生成海报
+	router.POST("/render/:template", s.renderTemplate)
 
 	// 启动监听
 	err = server.ListenAndServe()
@@ -64,7 +78,7 @@ func (s *HTTPTransport) middleware() gin.HandlerFunc {
 		c.Header("Access-Control-Allow-Origin", "*")
 		c.Header("Access-Control-Allow-Headers", "Content-Type,AccessToken,X-CSRF-Token")
 		c.Header("Access-Control-Allow-Methods", "POST, GET, OPTIONS, DELETE, PUT")
-		c.Header("Access-Control-Expose-Headers", "Content-Length, Access-Control-Allow-Origin, Access-Control-Allow-Headers, Content-Type")
+		c.Header("Access-Control-Expose-Headers", "Content-Length, Access-Control-Allow-Origin, Access-Control-Allow-Headers, Content-Type, X-Poster-Overflow")
 		c.Header("Access-Control-Allow-Credentials", "true")
 
 		//放行所有OPTIONS方法
@@ -86,7 +100,11 @@ func (s *HTTPTransport) createPoster(c *gin.Context) {
 		})
 		return
 	}
-	// 生成图片
+	s.drawPoster(c, req)
+}
+
+// 生成海报并响应图片
+func (s *HTTPTransport) drawPoster(c *gin.Context, req *service.PosterParam) {
 	srv, err := service.NewService(req)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -94,6 +112,22 @@ func (s *HTTPTransport) createPoster(c *gin.Context) {
 		})
 		return
 	}
+	// 多个倍数时返回json，图片为base64
+	if len(req.Scales) > 0 {
+		images, err := srv.DrawPosters()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"mime_type": srv.Param.Output.MimeType(),
+			"images":    images,
+			"overflow":  srv.Overflow,
+		})
+		return
+	}
 	img, err := srv.DrawPoster()
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -102,11 +136,143 @@ func (s *HTTPTransport) createPoster(c *gin.Context) {
 		return
 	}
 
+	// 超出文本框的文本，多个用逗号分隔
+	if len(srv.Overflow) > 0 {
+		c.Header("X-Poster-Overflow", strings.Join(srv.Overflow, ","))
+	}
 	// 直接输出图片方便测试
-	c.Header("Content-Type", "image/jpeg")
+	c.Header("Content-Type", srv.Param.Output.MimeType())
 	c.Writer.Write(img)
 	// // 返回图片，json格式
 	// c.JSON(http.StatusOK, gin.H{
 	// 	"image": img,
 	// })
 }
+
+// 获取可用字体列表
+func (s *HTTPTransport) listFonts(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"fonts": service.ListFonts(),
+	})
+}
+
+// 模板列表
+func (s *HTTPTransport) listTemplates(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"templates": template.Default().List(),
+	})
+}
+
+// 获取模板 - name可以是 name@版本号、name@latest
+func (s *HTTPTransport) getTemplate(c *gin.Context) {
+	t, err := template.Default().Get(c.Param("name"))
+	if err != nil {
+		templateError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, t)
+}
+
+// 创建或更新模板 - 请求体为 {"description": "", "param": {...}}
+func (s *HTTPTransport) saveTemplate(c *gin.Context) {
+	req := new(template.Template)
+	err := c.BindJSON(req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	req.Name = c.Param("name")
+	t, err := template.Default().Save(req)
+	if err != nil {
+		templateError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, t)
+}
+
+// 删除模板
+func (s *HTTPTransport) deleteTemplate(c *gin.Context) {
+	err := template.Default().Delete(c.Param("name"))
+	if err != nil {
+		templateError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// 模板的所有版本
+func (s *HTTPTransport) listTemplateVersions(c *gin.Context) {
+	versions, latest, err := template.Default().Versions(c.Param("name"))
+	if err != nil {
+		templateError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"latest":   latest,
+		"versions": versions,
+	})
+}
+
+// rollbackRequest 回滚模板的请求参数
+type rollbackRequest struct {
+	Version int `json:"version"` // latest回滚到的版本号
+}
+
+// 将模板latest回滚到之前的版本
+func (s *HTTPTransport) rollbackTemplate(c *gin.Context) {
+	req := new(rollbackRequest)
+	err := c.BindJSON(req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	t, err := template.Default().Rollback(c.Param("name"), req.Version)
+	if err != nil {
+		templateError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, t)
+}
+
+// renderRequest 使用模板生成海报的请求参数
+type renderRequest struct {
+	Variables map[string]interface{} `json:"variables"` // 变量 - 字符串、数字、布尔值，repeat使用的变量为列表
+}
+
+// 使用模板生成海报 - template可以是 name@版本号、name@latest
+func (s *HTTPTransport) renderTemplate(c *gin.Context) {
+	req := new(renderRequest)
+	// 数字保持原样，避免大数字转为科学计数法
+	dec := json.NewDecoder(c.Request.Body)
+	dec.UseNumber()
+	err := dec.Decode(req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	param, err := template.Default().Render(c.Param("template"), req.Variables)
+	if err != nil {
+		templateError(c, err)
+		return
+	}
+	s.drawPoster(c, param)
+}
+
+// templateError 模板或版本不存在时响应404，修改模板文件中的模板响应409，其他错误响应400
+func templateError(c *gin.Context, err error) {
+	status := http.StatusBadRequest
+	if err == template.ErrNotFound || err == template.ErrVersionNotFound {
+		status = http.StatusNotFound
+	} else if err == template.ErrReadOnly {
+		status = http.StatusConflict
+	}
+	c.JSON(status, gin.H{
+		"error": err.Error(),
+	})
+}